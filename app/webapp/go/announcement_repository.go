@@ -34,6 +34,16 @@ func (h *handlers) getAnnouncementDetail(ID string) (AnnouncementDetail, error)
 	return detail.(AnnouncementDetail), nil
 }
 
+// discardAnnouncementDetailsByCourse 科目名が変わった時にキャッシュしているお知らせ詳細を捨てる
+func discardAnnouncementDetailsByCourse(courseID string) {
+	announcementMap.Range(func(key, value interface{}) bool {
+		if value.(AnnouncementDetail).CourseID == courseID {
+			announcementMap.Delete(key)
+		}
+		return true
+	})
+}
+
 type userCourses struct {
 	courses map[string]bool
 	mu      sync.Mutex
@@ -76,6 +86,20 @@ func (h *handlers) isUserRegistered(userID string, courseID string) (bool, error
 	return registered, nil
 }
 
+// discardUserCourse 履修登録が取り消された時にキャッシュを捨てる
+func discardUserCourse(userID string, courseID string) {
+	userCoursesMapMutex.Lock()
+	cources, ok := userCoursesMap[userID]
+	userCoursesMapMutex.Unlock()
+	if !ok {
+		return
+	}
+
+	cources.mu.Lock()
+	delete(cources.courses, courseID)
+	cources.mu.Unlock()
+}
+
 var (
 	classToCourseMap      = map[string]string{}
 	classToCourseMapMutex sync.RWMutex
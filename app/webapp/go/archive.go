@@ -0,0 +1,255 @@
+package main
+
+import (
+	"database/sql"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/labstack/echo/v4"
+)
+
+// isCourseRegistrable 履修登録・キャンセル待ちができる科目か。アーカイブされた科目は登録できない
+func (h *handlers) isCourseRegistrable(course *Course) bool {
+	return course.Status == StatusRegistration && !course.ArchivedAt.Valid && h.isCourseRegistrationOpen(course)
+}
+
+// setCourseArchived アーカイブ状態を変更し、キャッシュを更新する
+func (h *handlers) setCourseArchived(c echo.Context, archived bool) error {
+	userID, _, _, err := getUserInfo(c)
+	if err != nil {
+		c.Logger().Error(err)
+		return c.NoContent(http.StatusInternalServerError)
+	}
+
+	courseID := c.Param("courseID")
+	ok, current := h.getCourse(courseID)
+	if !ok {
+		return c.String(http.StatusNotFound, "No such course.")
+	}
+	isStaff, err := h.isCourseStaff(courseID, userID)
+	if err != nil {
+		c.Logger().Error(err)
+		return c.NoContent(http.StatusInternalServerError)
+	}
+	if !isStaff {
+		return c.String(http.StatusForbidden, "You are not the teacher of this course.")
+	}
+	if current.ArchivedAt.Valid == archived {
+		return c.NoContent(http.StatusOK)
+	}
+
+	var archivedAt sql.NullTime
+	if archived {
+		archivedAt = sql.NullTime{Time: time.Now().Truncate(time.Microsecond), Valid: true}
+	}
+
+	tx, err := h.DB.Beginx()
+	if err != nil {
+		c.Logger().Error(err)
+		return c.NoContent(http.StatusInternalServerError)
+	}
+	defer tx.Rollback()
+
+	query := "UPDATE `courses` SET `archived_at` = ?, `version` = `version` + 1 WHERE `id` = ?"
+	h.SubDB.Exec(query, archivedAt, courseID)
+	if _, err := tx.Exec(query, archivedAt, courseID); err != nil {
+		c.Logger().Error(err)
+		return c.NoContent(http.StatusInternalServerError)
+	}
+	// アーカイブされた科目には繰り上がれないので、キャンセル待ちを取り消す
+	if archived {
+		query = "DELETE FROM `waitlists` WHERE `course_id` = ?"
+		h.SubDB.Exec(query, courseID)
+		if _, err := tx.Exec(query, courseID); err != nil {
+			c.Logger().Error(err)
+			return c.NoContent(http.StatusInternalServerError)
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		c.Logger().Error(err)
+		return c.NoContent(http.StatusInternalServerError)
+	}
+
+	course := *current
+	course.ArchivedAt = archivedAt
+	course.Version++
+	CourseCacheMux.Lock()
+	CourseCacheMap[courseID] = &course
+	CourseCacheMux.Unlock()
+
+	bumpVersion(catalogKey, courseKey(courseID))
+	return c.NoContent(http.StatusOK)
+}
+
+// ArchiveCourse POST /api/courses/:courseID/archive 科目のアーカイブ(検索・履修登録の対象から外す)
+func (h *handlers) ArchiveCourse(c echo.Context) error {
+	return h.setCourseArchived(c, true)
+}
+
+// UnarchiveCourse DELETE /api/courses/:courseID/archive 科目のアーカイブの解除
+func (h *handlers) UnarchiveCourse(c echo.Context) error {
+	return h.setCourseArchived(c, false)
+}
+
+// DeleteCourse DELETE /api/courses/:courseID 科目の削除(ownerのみ)
+// 履修者がいない科目のみ削除でき、講義・提出物・お知らせ・課題ファイル・講義資料なども合わせて削除する
+func (h *handlers) DeleteCourse(c echo.Context) error {
+	userID, _, _, err := getUserInfo(c)
+	if err != nil {
+		c.Logger().Error(err)
+		return c.NoContent(http.StatusInternalServerError)
+	}
+
+	courseID := c.Param("courseID")
+	ok, course := h.getCourse(courseID)
+	if !ok {
+		return c.String(http.StatusNotFound, "No such course.")
+	}
+	if course.TeacherID != userID {
+		return c.String(http.StatusForbidden, "You are not the owner of this course.")
+	}
+
+	tx, err := h.DB.Beginx()
+	if err != nil {
+		c.Logger().Error(err)
+		return c.NoContent(http.StatusInternalServerError)
+	}
+	defer tx.Rollback()
+
+	// 履修登録と競合しないよう科目の行ロックを取ってから履修者を確認する
+	if _, err := tx.Exec("SELECT 1 FROM `courses` WHERE `id` = ? FOR UPDATE", courseID); err != nil {
+		c.Logger().Error(err)
+		return c.NoContent(http.StatusInternalServerError)
+	}
+	// 定員のない科目の履修登録は科目の行ロックを取らないので、履修者もロック読み取りで数えて同時の登録を待たせる
+	count, err := countCourseRegistrations(tx, courseID)
+	if err != nil {
+		c.Logger().Error(err)
+		return c.NoContent(http.StatusInternalServerError)
+	}
+	if count > 0 {
+		return c.String(http.StatusConflict, "This course has registered students. Archive it instead.")
+	}
+	// 他の科目の前提科目になっている科目は削除できない
+	if err := tx.Get(&count, "SELECT COUNT(*) FROM `course_prerequisites` WHERE `prerequisite_code` = ? AND `course_id` != ? FOR UPDATE", course.Code, courseID); err != nil {
+		c.Logger().Error(err)
+		return c.NoContent(http.StatusInternalServerError)
+	}
+	if count > 0 {
+		return c.String(http.StatusConflict, "This course is a prerequisite of other courses.")
+	}
+
+	type submissionS struct {
+		ClassID string `db:"class_id"`
+		UserID  string `db:"user_id"`
+	}
+	var submissions []submissionS
+	query := "SELECT `submissions`.`class_id`, `submissions`.`user_id`" +
+		" FROM `submissions`" +
+		" JOIN `classes` ON `classes`.`id` = `submissions`.`class_id`" +
+		" WHERE `classes`.`course_id` = ?"
+	if err := tx.Select(&submissions, query, courseID); err != nil {
+		c.Logger().Error(err)
+		return c.NoContent(http.StatusInternalServerError)
+	}
+	var classIDs []string
+	if err := tx.Select(&classIDs, "SELECT `id` FROM `classes` WHERE `course_id` = ?", courseID); err != nil {
+		c.Logger().Error(err)
+		return c.NoContent(http.StatusInternalServerError)
+	}
+	var materials []ClassMaterial
+	query = "SELECT `class_materials`.*" +
+		" FROM `class_materials`" +
+		" JOIN `classes` ON `classes`.`id` = `class_materials`.`class_id`" +
+		" WHERE `classes`.`course_id` = ?"
+	if err := tx.Select(&materials, query, courseID); err != nil {
+		c.Logger().Error(err)
+		return c.NoContent(http.StatusInternalServerError)
+	}
+
+	// 子のテーブルから順に削除する
+	queries := []string{
+		"DELETE `submissions` FROM `submissions` JOIN `classes` ON `classes`.`id` = `submissions`.`class_id` WHERE `classes`.`course_id` = ?",
+		"DELETE `class_materials` FROM `class_materials` JOIN `classes` ON `classes`.`id` = `class_materials`.`class_id` WHERE `classes`.`course_id` = ?",
+		"DELETE `attendances` FROM `attendances` JOIN `classes` ON `classes`.`id` = `attendances`.`class_id` WHERE `classes`.`course_id` = ?",
+		"DELETE `attendance_attempts` FROM `attendance_attempts` JOIN `attendance_sessions` ON `attendance_sessions`.`id` = `attendance_attempts`.`session_id` JOIN `classes` ON `classes`.`id` = `attendance_sessions`.`class_id` WHERE `classes`.`course_id` = ?",
+		"DELETE `attendance_sessions` FROM `attendance_sessions` JOIN `classes` ON `classes`.`id` = `attendance_sessions`.`class_id` WHERE `classes`.`course_id` = ?",
+		"DELETE `deadline_extensions` FROM `deadline_extensions` JOIN `classes` ON `classes`.`id` = `deadline_extensions`.`class_id` WHERE `classes`.`course_id` = ?",
+		"DELETE FROM `classes` WHERE `course_id` = ?",
+		"DELETE `unread_announcements` FROM `unread_announcements` JOIN `announcements` ON `announcements`.`id` = `unread_announcements`.`announcement_id` WHERE `announcements`.`course_id` = ?",
+		"DELETE FROM `announcements` WHERE `course_id` = ?",
+		"DELETE FROM `user_course_total_scores` WHERE `course_id` = ?",
+		"DELETE FROM `registrations` WHERE `course_id` = ?",
+		"DELETE FROM `waitlists` WHERE `course_id` = ?",
+		"DELETE FROM `course_prerequisites` WHERE `course_id` = ?",
+		"DELETE FROM `course_slots` WHERE `course_id` = ?",
+		"DELETE FROM `course_staff` WHERE `course_id` = ?",
+		"DELETE FROM `syllabus_revisions` WHERE `course_id` = ?",
+		"DELETE FROM `courses` WHERE `id` = ?",
+	}
+	for _, query := range queries {
+		h.SubDB.Exec(query, courseID)
+		if _, err := tx.Exec(query, courseID); err != nil {
+			c.Logger().Error(err)
+			return c.NoContent(http.StatusInternalServerError)
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		c.Logger().Error(err)
+		return c.NoContent(http.StatusInternalServerError)
+	}
+
+	// 課題ファイルはコミット後に削除する。失敗してもDBからは参照されないのでログのみ
+	for _, s := range submissions {
+		if err := os.Remove(AssignmentsDirectory + s.ClassID + "-" + s.UserID + ".pdf"); err != nil && !os.IsNotExist(err) {
+			c.Logger().Error(err)
+		}
+	}
+	for _, classID := range classIDs {
+		if err := os.Remove(AssignmentsDirectory + classID + ".zip"); err != nil && !os.IsNotExist(err) {
+			c.Logger().Error(err)
+		}
+	}
+	for _, m := range materials {
+		if err := os.Remove(materialPath(m.ClassID, m.ID)); err != nil && !os.IsNotExist(err) {
+			c.Logger().Error(err)
+		}
+	}
+
+	discardCourseCaches(courseID, classIDs)
+	bumpVersion(catalogKey, courseKey(courseID), classesKey(courseID), gradesKey)
+	return c.NoContent(http.StatusNoContent)
+}
+
+// discardCourseCaches 削除した科目と講義のキャッシュを捨てる
+func discardCourseCaches(courseID string, classIDs []string) {
+	CourseCacheMux.Lock()
+	delete(CourseCacheMap, courseID)
+	CourseCacheMux.Unlock()
+
+	PrerequisiteCacheMux.Lock()
+	delete(PrerequisiteCacheMap, courseID)
+	PrerequisiteCacheMux.Unlock()
+
+	CourseSlotCacheMux.Lock()
+	delete(CourseSlotCacheMap, courseID)
+	CourseSlotCacheMux.Unlock()
+
+	discardCourseStaff(courseID)
+	discardAnnouncementDetailsByCourse(courseID)
+
+	ClassCacheMux.Lock()
+	for _, classID := range classIDs {
+		delete(ClassCacheMap, classID)
+	}
+	ClassCacheMux.Unlock()
+
+	classToCourseMapMutex.Lock()
+	for _, classID := range classIDs {
+		delete(classToCourseMap, classID)
+	}
+	classToCourseMapMutex.Unlock()
+}
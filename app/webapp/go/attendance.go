@@ -0,0 +1,552 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"database/sql"
+	"encoding/binary"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/labstack/echo/v4"
+)
+
+// 講義の出欠
+// 教員が講義中に出席受付(attendance_sessions)を開始し、学生は表示されたワンタイムコードを受付時間内に送信して出席する
+// 出欠を取った講義(受付を開始したか、出欠が記録された講義)のみを出席率の計算対象にする
+
+type AttendanceStatus string
+
+const (
+	AttendancePresent AttendanceStatus = "present"
+	AttendanceLate    AttendanceStatus = "late"
+	AttendanceAbsent  AttendanceStatus = "absent"
+	// 公欠。出席率の計算対象から外す
+	AttendanceExcused AttendanceStatus = "excused"
+)
+
+func isValidAttendanceStatus(status AttendanceStatus) bool {
+	return status == AttendancePresent || status == AttendanceLate || status == AttendanceAbsent || status == AttendanceExcused
+}
+
+const (
+	attendanceSessionDefaultDuration = 10 * time.Minute
+	attendanceSessionMaxDuration     = 3 * time.Hour
+	// 受付毎・学生毎の出席コードの誤入力の上限。6桁のコードを総当たりされないようにする
+	attendanceMaxFailures = 5
+)
+
+type AttendanceSession struct {
+	ID        string    `db:"id"`
+	ClassID   string    `db:"class_id"`
+	Code      string    `db:"code"`
+	OpensAt   time.Time `db:"opens_at"`
+	ExpiresAt time.Time `db:"expires_at"`
+	CreatedBy string    `db:"created_by"`
+}
+
+func newAttendanceCode() (string, error) {
+	b := make([]byte, 4)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%06d", binary.BigEndian.Uint32(b)%1000000), nil
+}
+
+// AttendanceSummary 学生の科目の出席状況
+type AttendanceSummary struct {
+	// 出欠を取った講義数
+	Classes int `json:"classes"`
+	// 出席(遅刻を含む)した講義数
+	Attended int `json:"attended"`
+	Excused  int `json:"excused"`
+	// 出席率(0~1)。対象の講義がなければnull
+	Rate *float64 `json:"rate"`
+}
+
+type attendanceStats struct {
+	// 科目ID -> 出欠を取った講義数
+	taken map[string]int
+	// attendanceKey(科目ID, 学生ID) -> 出席・公欠の講義数
+	attended map[string]int
+	excused  map[string]int
+}
+
+func attendanceKey(courseID string, userID string) string {
+	return courseID + "/" + userID
+}
+
+func (s *attendanceStats) summary(courseID string, userID string) AttendanceSummary {
+	key := attendanceKey(courseID, userID)
+	summary := AttendanceSummary{
+		Classes:  s.taken[courseID],
+		Attended: s.attended[key],
+		Excused:  s.excused[key],
+	}
+	if n := summary.Classes - summary.Excused; n > 0 {
+		rate := float64(summary.Attended) / float64(n)
+		summary.Rate = &rate
+	}
+	return summary
+}
+
+// getAttendanceStats 科目毎の出欠を集計する。userIDが空文字列の場合は全学生分
+func getAttendanceStats(db sqlx.Queryer, courseIDs []string, userID string) (*attendanceStats, error) {
+	stats := &attendanceStats{
+		taken:    map[string]int{},
+		attended: map[string]int{},
+		excused:  map[string]int{},
+	}
+	if len(courseIDs) == 0 {
+		return stats, nil
+	}
+	placeholders := "?" + strings.Repeat(", ?", len(courseIDs)-1)
+	args := make([]interface{}, 0, len(courseIDs)+1)
+	for _, courseID := range courseIDs {
+		args = append(args, courseID)
+	}
+
+	type takenS struct {
+		CourseID string `db:"course_id"`
+		Count    int    `db:"count"`
+	}
+	var taken []takenS
+	query := "SELECT `classes`.`course_id`, COUNT(*) AS `count`" +
+		" FROM `classes`" +
+		" WHERE `classes`.`course_id` IN (" + placeholders + ")" +
+		" AND (EXISTS (SELECT 1 FROM `attendance_sessions` WHERE `attendance_sessions`.`class_id` = `classes`.`id`)" +
+		"     OR EXISTS (SELECT 1 FROM `attendances` WHERE `attendances`.`class_id` = `classes`.`id`))" +
+		" GROUP BY `classes`.`course_id`"
+	if err := sqlx.Select(db, &taken, query, args...); err != nil {
+		return nil, err
+	}
+	for _, t := range taken {
+		stats.taken[t.CourseID] = t.Count
+	}
+
+	type recordS struct {
+		CourseID string           `db:"course_id"`
+		UserID   string           `db:"user_id"`
+		Status   AttendanceStatus `db:"status"`
+		Count    int              `db:"count"`
+	}
+	var records []recordS
+	query = "SELECT `classes`.`course_id`, `attendances`.`user_id`, `attendances`.`status`, COUNT(*) AS `count`" +
+		" FROM `attendances`" +
+		" JOIN `classes` ON `classes`.`id` = `attendances`.`class_id`" +
+		" WHERE `classes`.`course_id` IN (" + placeholders + ")"
+	if userID != "" {
+		query += " AND `attendances`.`user_id` = ?"
+		args = append(args, userID)
+	}
+	query += " GROUP BY `classes`.`course_id`, `attendances`.`user_id`, `attendances`.`status`"
+	if err := sqlx.Select(db, &records, query, args...); err != nil {
+		return nil, err
+	}
+	for _, r := range records {
+		key := attendanceKey(r.CourseID, r.UserID)
+		switch r.Status {
+		case AttendancePresent, AttendanceLate:
+			stats.attended[key] += r.Count
+		case AttendanceExcused:
+			stats.excused[key] += r.Count
+		}
+	}
+	return stats, nil
+}
+
+type OpenAttendanceSessionRequest struct {
+	// 受付時間(秒)。省略時は10分
+	Duration int `json:"duration"`
+}
+
+type OpenAttendanceSessionResponse struct {
+	ID        string    `json:"id"`
+	Code      string    `json:"code"`
+	OpensAt   time.Time `json:"opens_at"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// OpenAttendanceSession POST /api/courses/:courseID/classes/:classID/attendance/sessions 出席受付の開始
+// 受付中の出席受付があれば終了し、新しいコードを発行する
+func (h *handlers) OpenAttendanceSession(c echo.Context) error {
+	userID, _, _, err := getUserInfo(c)
+	if err != nil {
+		c.Logger().Error(err)
+		return c.NoContent(http.StatusInternalServerError)
+	}
+
+	courseID := c.Param("courseID")
+	classID := c.Param("classID")
+
+	var req OpenAttendanceSessionRequest
+	if c.Request().ContentLength != 0 {
+		if err := c.Bind(&req); err != nil {
+			return c.String(http.StatusBadRequest, "Invalid format.")
+		}
+	}
+
+	_, ok := h.checkClassStaff(c, courseID, classID)
+	if !ok {
+		return nil
+	}
+	if _, course := h.getCourse(courseID); course.Status != StatusInProgress {
+		return c.String(http.StatusBadRequest, "This course is not in-progress.")
+	}
+
+	duration := attendanceSessionDefaultDuration
+	if req.Duration != 0 {
+		duration = time.Duration(req.Duration) * time.Second
+		if duration < 0 || duration > attendanceSessionMaxDuration {
+			return c.String(http.StatusBadRequest, "Invalid duration.")
+		}
+	}
+
+	code, err := newAttendanceCode()
+	if err != nil {
+		c.Logger().Error(err)
+		return c.NoContent(http.StatusInternalServerError)
+	}
+	now := time.Now().Truncate(time.Microsecond)
+	session := AttendanceSession{
+		ID:        newULID(),
+		ClassID:   classID,
+		Code:      code,
+		OpensAt:   now,
+		ExpiresAt: now.Add(duration),
+		CreatedBy: userID,
+	}
+
+	tx, err := h.DB.Beginx()
+	if err != nil {
+		c.Logger().Error(err)
+		return c.NoContent(http.StatusInternalServerError)
+	}
+	defer tx.Rollback()
+
+	query := "UPDATE `attendance_sessions` SET `expires_at` = ? WHERE `class_id` = ? AND `expires_at` > ?"
+	h.SubDB.Exec(query, now, classID, now)
+	if _, err := tx.Exec(query, now, classID, now); err != nil {
+		c.Logger().Error(err)
+		return c.NoContent(http.StatusInternalServerError)
+	}
+	query = "INSERT INTO `attendance_sessions` (`id`, `class_id`, `code`, `opens_at`, `expires_at`, `created_by`) VALUES (?, ?, ?, ?, ?, ?)"
+	h.SubDB.Exec(query, session.ID, session.ClassID, session.Code, session.OpensAt, session.ExpiresAt, session.CreatedBy)
+	if _, err := tx.Exec(query, session.ID, session.ClassID, session.Code, session.OpensAt, session.ExpiresAt, session.CreatedBy); err != nil {
+		c.Logger().Error(err)
+		return c.NoContent(http.StatusInternalServerError)
+	}
+
+	if err := tx.Commit(); err != nil {
+		c.Logger().Error(err)
+		return c.NoContent(http.StatusInternalServerError)
+	}
+
+	// 出欠を取った講義が増えるので出席率が変わる
+	bumpVersion(gradesKey)
+	return c.JSON(http.StatusCreated, OpenAttendanceSessionResponse{
+		ID:        session.ID,
+		Code:      session.Code,
+		OpensAt:   session.OpensAt,
+		ExpiresAt: session.ExpiresAt,
+	})
+}
+
+type SubmitAttendanceRequest struct {
+	Code string `json:"code"`
+}
+
+// SubmitAttendance POST /api/courses/:courseID/classes/:classID/attendance 出席コードの送信
+func (h *handlers) SubmitAttendance(c echo.Context) error {
+	userID, _, _, err := getUserInfo(c)
+	if err != nil {
+		c.Logger().Error(err)
+		return c.NoContent(http.StatusInternalServerError)
+	}
+
+	courseID := c.Param("courseID")
+	classID := c.Param("classID")
+
+	var req SubmitAttendanceRequest
+	if err := c.Bind(&req); err != nil {
+		return c.String(http.StatusBadRequest, "Invalid format.")
+	}
+
+	ok, course := h.getCourse(courseID)
+	if !ok {
+		return c.String(http.StatusNotFound, "No such course.")
+	}
+	if course.Status != StatusInProgress {
+		return c.String(http.StatusBadRequest, "This course is not in progress.")
+	}
+	registered, err := h.isUserRegistered(userID, courseID)
+	if err != nil {
+		c.Logger().Error(err)
+		return c.NoContent(http.StatusInternalServerError)
+	}
+	if !registered {
+		return c.String(http.StatusBadRequest, "You have not taken this course.")
+	}
+	ok, class := h.getClass(classID)
+	if !ok || class.CourseID != courseID {
+		return c.String(http.StatusNotFound, "No such class.")
+	}
+
+	now := time.Now().Truncate(time.Microsecond)
+	var session AttendanceSession
+	query := "SELECT * FROM `attendance_sessions` WHERE `class_id` = ? AND `opens_at` <= ? AND `expires_at` > ? ORDER BY `opens_at` DESC LIMIT 1"
+	if err := h.DB.Get(&session, query, classID, now, now); err != nil {
+		if err == sql.ErrNoRows {
+			return c.String(http.StatusBadRequest, "Attendance is not being taken for this class.")
+		}
+		c.Logger().Error(err)
+		return c.NoContent(http.StatusInternalServerError)
+	}
+
+	// 誤入力の回数を数える行をロックして、同時に送信されても上限を超えないようにする
+	tx, err := h.DB.Beginx()
+	if err != nil {
+		c.Logger().Error(err)
+		return c.NoContent(http.StatusInternalServerError)
+	}
+	defer tx.Rollback()
+
+	query = "INSERT IGNORE INTO `attendance_attempts` (`session_id`, `user_id`) VALUES (?, ?)"
+	h.SubDB.Exec(query, session.ID, userID)
+	if _, err := tx.Exec(query, session.ID, userID); err != nil {
+		c.Logger().Error(err)
+		return c.NoContent(http.StatusInternalServerError)
+	}
+	var failures int
+	if err := tx.Get(&failures, "SELECT `failures` FROM `attendance_attempts` WHERE `session_id` = ? AND `user_id` = ? FOR UPDATE", session.ID, userID); err != nil {
+		c.Logger().Error(err)
+		return c.NoContent(http.StatusInternalServerError)
+	}
+	if failures >= attendanceMaxFailures {
+		return c.String(http.StatusTooManyRequests, "Too many invalid attendance codes.")
+	}
+	if subtle.ConstantTimeCompare([]byte(req.Code), []byte(session.Code)) != 1 {
+		query = "UPDATE `attendance_attempts` SET `failures` = `failures` + 1 WHERE `session_id` = ? AND `user_id` = ?"
+		h.SubDB.Exec(query, session.ID, userID)
+		if _, err := tx.Exec(query, session.ID, userID); err != nil {
+			c.Logger().Error(err)
+			return c.NoContent(http.StatusInternalServerError)
+		}
+		if err := tx.Commit(); err != nil {
+			c.Logger().Error(err)
+			return c.NoContent(http.StatusInternalServerError)
+		}
+		return c.String(http.StatusBadRequest, "Invalid attendance code.")
+	}
+
+	// 教員が既に記録した出欠(遅刻・公欠など)は上書きしない
+	query = "INSERT INTO `attendances` (`class_id`, `user_id`, `status`, `session_id`, `recorded_at`) VALUES (?, ?, ?, ?, ?)" +
+		" ON DUPLICATE KEY UPDATE `class_id` = `class_id`"
+	h.SubDB.Exec(query, classID, userID, AttendancePresent, session.ID, now)
+	if _, err := tx.Exec(query, classID, userID, AttendancePresent, session.ID, now); err != nil {
+		c.Logger().Error(err)
+		return c.NoContent(http.StatusInternalServerError)
+	}
+
+	if err := tx.Commit(); err != nil {
+		c.Logger().Error(err)
+		return c.NoContent(http.StatusInternalServerError)
+	}
+
+	bumpVersion(gradesKey)
+	return c.NoContent(http.StatusNoContent)
+}
+
+type ClassAttendance struct {
+	UserCode   string            `json:"user_code" db:"user_code"`
+	UserName   string            `json:"user_name" db:"user_name"`
+	Status     *AttendanceStatus `json:"status" db:"status"`
+	RecordedAt *time.Time        `json:"recorded_at" db:"recorded_at"`
+}
+
+// GetClassAttendance GET /api/courses/:courseID/classes/:classID/attendance 講義の履修者全員の出欠(未記録はnull)
+func (h *handlers) GetClassAttendance(c echo.Context) error {
+	courseID := c.Param("courseID")
+	classID := c.Param("classID")
+
+	_, ok := h.checkClassStaff(c, courseID, classID)
+	if !ok {
+		return nil
+	}
+
+	// 結果が0件の時は空配列を返却
+	attendances := make([]ClassAttendance, 0)
+	query := "SELECT `users`.`code` AS `user_code`, `users`.`name` AS `user_name`, `attendances`.`status`, `attendances`.`recorded_at`" +
+		" FROM `registrations`" +
+		" JOIN `users` ON `users`.`id` = `registrations`.`user_id`" +
+		" LEFT JOIN `attendances` ON `attendances`.`user_id` = `registrations`.`user_id` AND `attendances`.`class_id` = ?" +
+		" WHERE `registrations`.`course_id` = ?" +
+		" ORDER BY `users`.`code`"
+	if err := h.Balance().Select(&attendances, query, classID, courseID); err != nil {
+		c.Logger().Error(err)
+		return c.NoContent(http.StatusInternalServerError)
+	}
+
+	return c.JSON(http.StatusOK, attendances)
+}
+
+type UpdateAttendanceRequest struct {
+	UserCode string `json:"user_code"`
+	// 空文字列の場合は記録を削除する
+	Status AttendanceStatus `json:"status"`
+}
+
+// UpdateClassAttendance PUT /api/courses/:courseID/classes/:classID/attendance 講義の出欠の一括編集
+func (h *handlers) UpdateClassAttendance(c echo.Context) error {
+	courseID := c.Param("courseID")
+	classID := c.Param("classID")
+
+	var req []UpdateAttendanceRequest
+	if err := c.Bind(&req); err != nil {
+		return c.String(http.StatusBadRequest, "Invalid format.")
+	}
+
+	_, ok := h.checkClassStaff(c, courseID, classID)
+	if !ok {
+		return nil
+	}
+	if len(req) == 0 {
+		return c.NoContent(http.StatusNoContent)
+	}
+
+	codes := make([]interface{}, 0, len(req)+1)
+	codes = append(codes, courseID)
+	for _, r := range req {
+		if r.Status != "" && !isValidAttendanceStatus(r.Status) {
+			return c.String(http.StatusBadRequest, "Invalid attendance status.")
+		}
+		codes = append(codes, r.UserCode)
+	}
+
+	type studentS struct {
+		ID   string `db:"id"`
+		Code string `db:"code"`
+	}
+	var students []studentS
+	query := "SELECT `users`.`id`, `users`.`code`" +
+		" FROM `users`" +
+		" JOIN `registrations` ON `registrations`.`user_id` = `users`.`id` AND `registrations`.`course_id` = ?" +
+		" WHERE `users`.`code` IN (?" + strings.Repeat(", ?", len(req)-1) + ")"
+	if err := h.Balance().Select(&students, query, codes...); err != nil {
+		c.Logger().Error(err)
+		return c.NoContent(http.StatusInternalServerError)
+	}
+	studentIDs := make(map[string]string, len(students))
+	for _, s := range students {
+		studentIDs[s.Code] = s.ID
+	}
+	for _, r := range req {
+		if _, ok := studentIDs[r.UserCode]; !ok {
+			return c.String(http.StatusBadRequest, "The student has not taken this course: "+r.UserCode)
+		}
+	}
+
+	tx, err := h.DB.Beginx()
+	if err != nil {
+		c.Logger().Error(err)
+		return c.NoContent(http.StatusInternalServerError)
+	}
+	defer tx.Rollback()
+
+	now := time.Now().Truncate(time.Microsecond)
+	for _, r := range req {
+		studentID := studentIDs[r.UserCode]
+		if r.Status == "" {
+			query := "DELETE FROM `attendances` WHERE `class_id` = ? AND `user_id` = ?"
+			h.SubDB.Exec(query, classID, studentID)
+			if _, err := tx.Exec(query, classID, studentID); err != nil {
+				c.Logger().Error(err)
+				return c.NoContent(http.StatusInternalServerError)
+			}
+			continue
+		}
+		query := "INSERT INTO `attendances` (`class_id`, `user_id`, `status`, `recorded_at`) VALUES (?, ?, ?, ?)" +
+			" ON DUPLICATE KEY UPDATE `status` = VALUES(`status`), `recorded_at` = VALUES(`recorded_at`)"
+		h.SubDB.Exec(query, classID, studentID, r.Status, now)
+		if _, err := tx.Exec(query, classID, studentID, r.Status, now); err != nil {
+			c.Logger().Error(err)
+			return c.NoContent(http.StatusInternalServerError)
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		c.Logger().Error(err)
+		return c.NoContent(http.StatusInternalServerError)
+	}
+
+	bumpVersion(gradesKey)
+	return c.NoContent(http.StatusNoContent)
+}
+
+type AttendanceReport struct {
+	UserCode   string `json:"user_code"`
+	UserName   string `json:"user_name"`
+	TotalScore int    `json:"total_score"`
+	AttendanceSummary
+}
+
+// GetAttendanceReport GET /api/courses/:courseID/attendance 履修者毎の出席率と合計点
+func (h *handlers) GetAttendanceReport(c echo.Context) error {
+	userID, _, _, err := getUserInfo(c)
+	if err != nil {
+		c.Logger().Error(err)
+		return c.NoContent(http.StatusInternalServerError)
+	}
+
+	courseID := c.Param("courseID")
+	if ok, _ := h.getCourse(courseID); !ok {
+		return c.String(http.StatusNotFound, "No such course.")
+	}
+	isStaff, err := h.isCourseStaff(courseID, userID)
+	if err != nil {
+		c.Logger().Error(err)
+		return c.NoContent(http.StatusInternalServerError)
+	}
+	if !isStaff {
+		return c.String(http.StatusForbidden, "You are not the teacher of this course.")
+	}
+
+	type studentS struct {
+		ID         string `db:"id"`
+		Code       string `db:"code"`
+		Name       string `db:"name"`
+		TotalScore int    `db:"total_score"`
+	}
+	var students []studentS
+	query := "SELECT `users`.`id`, `users`.`code`, `users`.`name`, IFNULL(`user_course_total_scores`.`total_score`, 0) AS `total_score`" +
+		" FROM `registrations`" +
+		" JOIN `users` ON `users`.`id` = `registrations`.`user_id`" +
+		" LEFT JOIN `user_course_total_scores` ON `user_course_total_scores`.`user_id` = `registrations`.`user_id` AND `user_course_total_scores`.`course_id` = `registrations`.`course_id`" +
+		" WHERE `registrations`.`course_id` = ?" +
+		" ORDER BY `users`.`code`"
+	if err := h.Balance().Select(&students, query, courseID); err != nil {
+		c.Logger().Error(err)
+		return c.NoContent(http.StatusInternalServerError)
+	}
+	stats, err := getAttendanceStats(h.Balance(), []string{courseID}, "")
+	if err != nil {
+		c.Logger().Error(err)
+		return c.NoContent(http.StatusInternalServerError)
+	}
+
+	// 結果が0件の時は空配列を返却
+	res := make([]AttendanceReport, 0, len(students))
+	for _, s := range students {
+		res = append(res, AttendanceReport{
+			UserCode:          s.Code,
+			UserName:          s.Name,
+			TotalScore:        s.TotalScore,
+			AttendanceSummary: stats.summary(courseID, s.ID),
+		})
+	}
+
+	return c.JSON(http.StatusOK, res)
+}
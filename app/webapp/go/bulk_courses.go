@@ -0,0 +1,688 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/goccy/go-json"
+	"github.com/jmoiron/sqlx"
+	"github.com/labstack/echo/v4"
+)
+
+// 科目の一括登録・出力
+// CSVでは複数の値を持つ列を ; で区切り、時限は day_of_week:period、前提科目は code:min_total_score で表す
+
+// CourseRecord 一括登録・出力での科目一件。教員はIDではなく教員コードで指定する
+type CourseRecord struct {
+	Code           string         `json:"code"`
+	Type           CourseType     `json:"type"`
+	Name           string         `json:"name"`
+	Description    string         `json:"description"`
+	Credit         int            `json:"credit"`
+	Slots          []CourseSlot   `json:"slots"`
+	TeacherCode    string         `json:"teacher_code"`
+	CoTeacherCodes []string       `json:"co_teacher_codes"`
+	Keywords       string         `json:"keywords"`
+	TermID         string         `json:"term_id"`
+	Capacity       int            `json:"capacity"` // 0は定員なし
+	Prerequisites  []Prerequisite `json:"prerequisites"`
+	// 出力時のみ。登録する科目は常にregistrationになる
+	Status CourseStatus `json:"status,omitempty"`
+}
+
+var courseRecordColumns = []string{"code", "type", "name", "description", "credit", "slots", "teacher_code", "co_teacher_codes", "keywords", "term_id", "capacity", "prerequisites", "status"}
+
+type ImportRowError struct {
+	Row     int    `json:"row"` // 1始まり。CSVではヘッダ行を除いた行番号
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+type ImportCoursesResponse struct {
+	Imported int              `json:"imported"`
+	Skipped  int              `json:"skipped"`
+	Errors   []ImportRowError `json:"errors"`
+}
+
+func splitList(s string) []string {
+	var items []string
+	for _, item := range strings.Split(s, ";") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+// parseCourseCSV ヘッダ行の列名で値を対応付ける。列の順序は問わない
+// 値の形式が不正な行は行番号毎のエラーとして返す
+func parseCourseCSV(r io.Reader) ([]CourseRecord, []ImportRowError, error) {
+	reader := csv.NewReader(r)
+	reader.FieldsPerRecord = -1
+	rows, err := reader.ReadAll()
+	if err != nil {
+		return nil, nil, err
+	}
+	if len(rows) == 0 {
+		return nil, nil, fmt.Errorf("header row is required")
+	}
+
+	columns := make(map[string]int, len(rows[0]))
+	for i, name := range rows[0] {
+		columns[strings.TrimSpace(strings.TrimPrefix(name, "\ufeff"))] = i
+	}
+	for _, name := range []string{"code", "type", "name", "credit", "slots", "teacher_code"} {
+		if _, ok := columns[name]; !ok {
+			return nil, nil, fmt.Errorf("column %s is required", name)
+		}
+	}
+
+	records := make([]CourseRecord, 0, len(rows)-1)
+	var rowErrors []ImportRowError
+	for i, row := range rows[1:] {
+		get := func(name string) string {
+			if idx, ok := columns[name]; ok && idx < len(row) {
+				return strings.TrimSpace(row[idx])
+			}
+			return ""
+		}
+		addError := func(message string) {
+			rowErrors = append(rowErrors, ImportRowError{Row: i + 1, Code: get("code"), Message: message})
+		}
+
+		record := CourseRecord{
+			Code:           get("code"),
+			Type:           CourseType(get("type")),
+			Name:           get("name"),
+			Description:    get("description"),
+			TeacherCode:    get("teacher_code"),
+			CoTeacherCodes: splitList(get("co_teacher_codes")),
+			Keywords:       get("keywords"),
+			TermID:         get("term_id"),
+			Status:         CourseStatus(get("status")),
+		}
+		if record.Credit, err = strconv.Atoi(get("credit")); err != nil {
+			addError("Invalid credit.")
+		}
+		if s := get("capacity"); s != "" {
+			if record.Capacity, err = strconv.Atoi(s); err != nil {
+				addError("Invalid capacity.")
+			}
+		}
+		for _, item := range splitList(get("slots")) {
+			kv := strings.SplitN(item, ":", 2)
+			period, err := strconv.Atoi(kv[len(kv)-1])
+			if len(kv) != 2 || err != nil || period <= 0 || period > 255 {
+				addError("Invalid slots.")
+				break
+			}
+			record.Slots = append(record.Slots, CourseSlot{DayOfWeek: DayOfWeek(kv[0]), Period: uint8(period)})
+		}
+		for _, item := range splitList(get("prerequisites")) {
+			kv := strings.SplitN(item, ":", 2)
+			p := Prerequisite{Code: kv[0]}
+			if len(kv) == 2 {
+				if p.MinTotalScore, err = strconv.Atoi(kv[1]); err != nil {
+					addError("Invalid prerequisites.")
+					break
+				}
+			}
+			record.Prerequisites = append(record.Prerequisites, p)
+		}
+		records = append(records, record)
+	}
+	return records, rowErrors, nil
+}
+
+func writeCourseCSV(w io.Writer, records []CourseRecord) error {
+	writer := csv.NewWriter(w)
+	if err := writer.Write(courseRecordColumns); err != nil {
+		return err
+	}
+	for _, record := range records {
+		slots := make([]string, 0, len(record.Slots))
+		for _, slot := range record.Slots {
+			slots = append(slots, fmt.Sprintf("%s:%d", slot.DayOfWeek, slot.Period))
+		}
+		prerequisites := make([]string, 0, len(record.Prerequisites))
+		for _, p := range record.Prerequisites {
+			prerequisites = append(prerequisites, fmt.Sprintf("%s:%d", p.Code, p.MinTotalScore))
+		}
+		capacity := ""
+		if record.Capacity > 0 {
+			capacity = strconv.Itoa(record.Capacity)
+		}
+		row := []string{
+			record.Code,
+			string(record.Type),
+			record.Name,
+			record.Description,
+			strconv.Itoa(record.Credit),
+			strings.Join(slots, ";"),
+			record.TeacherCode,
+			strings.Join(record.CoTeacherCodes, ";"),
+			record.Keywords,
+			record.TermID,
+			capacity,
+			strings.Join(prerequisites, ";"),
+			string(record.Status),
+		}
+		if err := writer.Write(row); err != nil {
+			return err
+		}
+	}
+	writer.Flush()
+	return writer.Error()
+}
+
+// parseCourseRecords CSVかJSON(CourseRecordの配列)を読む
+func parseCourseRecords(r io.Reader, format string) ([]CourseRecord, []ImportRowError, error) {
+	if format == "csv" {
+		return parseCourseCSV(r)
+	}
+	var records []CourseRecord
+	if err := json.NewDecoder(r).Decode(&records); err != nil {
+		return nil, nil, err
+	}
+	return records, nil, nil
+}
+
+type plannedCourse struct {
+	course       Course
+	record       CourseRecord
+	coTeacherIDs []string
+}
+
+// isSameCourse 登録済みの科目が行と同じ内容か。出力される項目をすべて比べる
+// slotsは並べ替え済みのもの、coTeacherIDsは共同担当の教員のIDを渡す
+func (h *handlers) isSameCourse(existing *Course, record CourseRecord, slots []CourseSlot, teacherID string, coTeacherIDs []string) (bool, error) {
+	if record.Type != existing.Type || record.Name != existing.Name || record.Description != existing.Description ||
+		record.Credit != int(existing.Credit) || teacherID != existing.TeacherID || record.Keywords != existing.Keywords ||
+		record.TermID != existing.TermID.String || record.Capacity != int(existing.Capacity.Int32) {
+		return false, nil
+	}
+
+	existingSlots, err := h.getCourseSlots(existing.ID, existing.primarySlot())
+	if err != nil {
+		return false, err
+	}
+	if !equalSlots(slots, existingSlots) {
+		return false, nil
+	}
+
+	prerequisites, err := h.getPrerequisites(existing.ID)
+	if err != nil {
+		return false, err
+	}
+	if len(prerequisites) != len(record.Prerequisites) {
+		return false, nil
+	}
+	minScores := make(map[string]int, len(prerequisites))
+	for _, p := range prerequisites {
+		minScores[p.Code] = p.MinTotalScore
+	}
+	for _, p := range record.Prerequisites {
+		if score, ok := minScores[p.Code]; !ok || score != p.MinTotalScore {
+			return false, nil
+		}
+	}
+
+	staff, err := h.getCourseStaff(existing.ID)
+	if err != nil {
+		return false, err
+	}
+	coTeachers := make(map[string]bool, len(staff))
+	for _, s := range staff {
+		if s.Role != RoleOwner {
+			coTeachers[s.UserID] = true
+		}
+	}
+	if len(coTeachers) != len(coTeacherIDs) {
+		return false, nil
+	}
+	for _, id := range coTeacherIDs {
+		if !coTeachers[id] {
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+// validateCourseRecords すべての行を検査し、登録する科目とエラーを返す
+// parseErrorsには読み込み時の行毎のエラーを渡し、検査のエラーと合わせて行番号順に返す
+// 同じ内容の科目がすでに登録されている行はスキップする
+func (h *handlers) validateCourseRecords(db sqlx.Queryer, records []CourseRecord, parseErrors []ImportRowError, ownerID string) ([]plannedCourse, int, []ImportRowError, error) {
+	var planned []plannedCourse
+	var rowErrors []ImportRowError
+	skipped := 0
+	parseMessages := make(map[int][]string, len(parseErrors))
+	for _, e := range parseErrors {
+		parseMessages[e.Row] = append(parseMessages[e.Row], e.Message)
+	}
+	seen := make(map[string]int, len(records))
+	teachers := map[string]*User{}
+	getTeacher := func(code string) (*User, error) {
+		if teacher, ok := teachers[code]; ok {
+			return teacher, nil
+		}
+		teacher, err := getTeacherByCode(db, code)
+		if err != nil {
+			return nil, err
+		}
+		teachers[code] = teacher
+		return teacher, nil
+	}
+
+	for i, record := range records {
+		row := i + 1
+		messages := append([]string{}, parseMessages[row]...)
+		addError := func(message string) {
+			// 読み込み時と同じエラーは重ねない
+			for _, m := range messages {
+				if m == message {
+					return
+				}
+			}
+			messages = append(messages, message)
+		}
+
+		if record.Code == "" || len(record.Code) > 255 {
+			addError("Invalid course code.")
+		} else if prev, ok := seen[record.Code]; ok {
+			addError(fmt.Sprintf("Duplicate course code in row %d.", prev))
+		} else {
+			seen[record.Code] = row
+		}
+		if record.Type != LiberalArts && record.Type != MajorSubjects {
+			addError("Invalid course type.")
+		}
+		if record.Name == "" || len(record.Name) > 255 {
+			addError("Invalid course name.")
+		}
+		if record.Credit <= 0 || record.Credit > 255 {
+			addError("Invalid credit.")
+		}
+		slots := append([]CourseSlot{}, record.Slots...)
+		if !validateSlots(slots) {
+			addError("Invalid slots.")
+		} else {
+			sortSlots(slots)
+		}
+		if record.Capacity < 0 {
+			addError("Invalid capacity.")
+		}
+		if record.TermID != "" {
+			if ok, _ := h.getTerm(record.TermID); !ok {
+				addError("No such term.")
+			}
+		}
+		if !validatePrerequisites(record.Code, record.Prerequisites) {
+			addError("Invalid prerequisites.")
+		}
+
+		teacher, err := getTeacher(record.TeacherCode)
+		if err != nil {
+			return nil, 0, nil, err
+		}
+		if teacher == nil {
+			addError("No such teacher: " + record.TeacherCode + ".")
+		} else if ownerID != "" && teacher.ID != ownerID {
+			// AddCourseと同じく、他の教員が担当する科目は登録できない
+			addError("You can only import courses you teach.")
+		}
+		var coTeacherIDs []string
+		coTeachers := map[string]bool{record.TeacherCode: true}
+		for _, code := range record.CoTeacherCodes {
+			if coTeachers[code] {
+				addError("Duplicate teacher: " + code + ".")
+				continue
+			}
+			coTeachers[code] = true
+			coTeacher, err := getTeacher(code)
+			if err != nil {
+				return nil, 0, nil, err
+			}
+			if coTeacher == nil {
+				addError("No such teacher: " + code + ".")
+				continue
+			}
+			coTeacherIDs = append(coTeacherIDs, coTeacher.ID)
+		}
+
+		exists := false
+		if len(messages) == 0 {
+			var existing Course
+			if err := sqlx.Get(db, &existing, "SELECT * FROM `courses` WHERE `code` = ?", record.Code); err != nil && err != sql.ErrNoRows {
+				return nil, 0, nil, err
+			} else if err == nil {
+				same, err := h.isSameCourse(&existing, record, slots, teacher.ID, coTeacherIDs)
+				if err != nil {
+					return nil, 0, nil, err
+				}
+				if same {
+					skipped++
+				} else {
+					addError("A course with the same code already exists.")
+				}
+				exists = true
+			}
+		}
+
+		for _, message := range messages {
+			rowErrors = append(rowErrors, ImportRowError{Row: row, Code: record.Code, Message: message})
+		}
+		if len(messages) > 0 || exists {
+			continue
+		}
+
+		record.Slots = slots
+		planned = append(planned, plannedCourse{
+			course: Course{
+				ID:          newULID(),
+				Code:        record.Code,
+				Type:        record.Type,
+				Name:        record.Name,
+				Description: record.Description,
+				Credit:      uint8(record.Credit),
+				Period:      slots[0].Period,
+				DayOfWeek:   slots[0].DayOfWeek,
+				TeacherID:   teacher.ID,
+				Keywords:    record.Keywords,
+				Status:      StatusRegistration,
+				TermID:      sql.NullString{String: record.TermID, Valid: record.TermID != ""},
+				Capacity:    sql.NullInt32{Int32: int32(record.Capacity), Valid: record.Capacity > 0},
+			},
+			record:       record,
+			coTeacherIDs: coTeacherIDs,
+		})
+	}
+	return planned, skipped, rowErrors, nil
+}
+
+// importCourses 科目を一括登録する。一件でもエラー(読み込み時のエラーを含む)があれば何も登録しない
+// ownerIDを指定した場合はその教員が担当する科目のみ登録できる。空文字列(コマンドから)の場合は制限しない
+func (h *handlers) importCourses(records []CourseRecord, parseErrors []ImportRowError, ownerID string, dryRun bool) (*ImportCoursesResponse, error) {
+	tx, err := h.DB.Beginx()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	planned, skipped, rowErrors, err := h.validateCourseRecords(tx, records, parseErrors, ownerID)
+	if err != nil {
+		return nil, err
+	}
+	res := &ImportCoursesResponse{
+		Imported: len(planned),
+		Skipped:  skipped,
+		Errors:   rowErrors,
+	}
+	if len(rowErrors) > 0 {
+		res.Imported = 0
+		return res, nil
+	}
+	// 結果が0件の時は空配列を返却
+	res.Errors = make([]ImportRowError, 0)
+	if dryRun {
+		return res, nil
+	}
+
+	// SubDBへの書き込みはコミットが成功してから流し、途中で失敗してもSubDBに一部の科目だけが残らないようにする
+	sub := &deferredExecer{}
+	query := "INSERT INTO `courses` (`id`, `code`, `type`, `name`, `description`, `credit`, `period`, `day_of_week`, `teacher_id`, `keywords`, `term_id`, `capacity`) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)"
+	for _, p := range planned {
+		course := p.course
+		sub.Exec(query, course.ID, course.Code, course.Type, course.Name, course.Description, course.Credit, course.Period, course.DayOfWeek, course.TeacherID, course.Keywords, course.TermID, course.Capacity)
+		if _, err := tx.Exec(query, course.ID, course.Code, course.Type, course.Name, course.Description, course.Credit, course.Period, course.DayOfWeek, course.TeacherID, course.Keywords, course.TermID, course.Capacity); err != nil {
+			return nil, err
+		}
+		if err := h.insertPrerequisites(tx, sub, course.ID, p.record.Prerequisites); err != nil {
+			return nil, err
+		}
+		if err := h.insertCourseSlots(tx, sub, course.ID, p.record.Slots); err != nil {
+			return nil, err
+		}
+		if err := h.insertCourseStaff(tx, sub, course.ID, course.TeacherID, RoleOwner); err != nil {
+			return nil, err
+		}
+		if _, err := h.addSyllabusRevision(tx, sub, &course, course.TeacherID); err != nil {
+			return nil, err
+		}
+		for _, coTeacherID := range p.coTeacherIDs {
+			if err := h.insertCourseStaff(tx, sub, course.ID, coTeacherID, RoleCoTeacher); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	sub.flush(h.SubDB)
+
+	for i := range planned {
+		course := planned[i].course
+		cachePrerequisites(course.ID, planned[i].record.Prerequisites)
+		cacheCourseSlots(course.ID, planned[i].record.Slots)
+		discardCourseStaff(course.ID)
+		CourseCacheMux.Lock()
+		CourseCacheMap[course.ID] = &course
+		CourseCacheMux.Unlock()
+	}
+	if len(planned) > 0 {
+		bumpVersion(catalogKey)
+	}
+	return res, nil
+}
+
+// exportCourses 科目を科目コード順に出力する。termIDが空の場合はすべての科目
+func (h *handlers) exportCourses(termID string) ([]CourseRecord, error) {
+	var courses []Course
+	query := "SELECT * FROM `courses`"
+	var args []interface{}
+	if termID != "" {
+		query += " WHERE `term_id` = ?"
+		args = append(args, termID)
+	}
+	query += " ORDER BY `code`"
+	if err := h.Balance().Select(&courses, query, args...); err != nil {
+		return nil, err
+	}
+
+	records := make([]CourseRecord, 0, len(courses))
+	for _, course := range courses {
+		slots, err := h.getCourseSlots(course.ID, course.primarySlot())
+		if err != nil {
+			return nil, err
+		}
+		prerequisites, err := h.getPrerequisites(course.ID)
+		if err != nil {
+			return nil, err
+		}
+		staff, err := h.getCourseStaff(course.ID)
+		if err != nil {
+			return nil, err
+		}
+
+		record := CourseRecord{
+			Code:           course.Code,
+			Type:           course.Type,
+			Name:           course.Name,
+			Description:    course.Description,
+			Credit:         int(course.Credit),
+			Slots:          slots,
+			CoTeacherCodes: []string{},
+			Keywords:       course.Keywords,
+			TermID:         course.TermID.String,
+			Capacity:       int(course.Capacity.Int32),
+			Prerequisites:  prerequisites,
+			Status:         course.Status,
+		}
+		for _, s := range staff {
+			if s.Role == RoleOwner {
+				record.TeacherCode = s.Code
+			} else {
+				record.CoTeacherCodes = append(record.CoTeacherCodes, s.Code)
+			}
+		}
+		records = append(records, record)
+	}
+	return records, nil
+}
+
+func importFormat(format string, contentType string) string {
+	if format == "csv" || format == "" && strings.Contains(contentType, "csv") {
+		return "csv"
+	}
+	return "json"
+}
+
+// ImportCourses POST /api/courses/import 科目の一括登録(CSV/JSON)
+// dry_run=true の時は検査のみ行う。エラーがある場合は400と行毎のエラーを返し、何も登録しない
+// teacher_codeには自分の教員コードのみ指定できる
+func (h *handlers) ImportCourses(c echo.Context) error {
+	userID, _, _, err := getUserInfo(c)
+	if err != nil {
+		c.Logger().Error(err)
+		return c.NoContent(http.StatusInternalServerError)
+	}
+
+	format := importFormat(c.QueryParam("format"), c.Request().Header.Get(echo.HeaderContentType))
+	records, rowErrors, err := parseCourseRecords(c.Request().Body, format)
+	if err != nil {
+		return c.String(http.StatusBadRequest, "Invalid format.")
+	}
+	dryRun, _ := strconv.ParseBool(c.QueryParam("dry_run"))
+
+	res, err := h.importCourses(records, rowErrors, userID, dryRun)
+	if err != nil {
+		c.Logger().Error(err)
+		return c.NoContent(http.StatusInternalServerError)
+	}
+	if len(res.Errors) > 0 {
+		return c.JSON(http.StatusBadRequest, res)
+	}
+	return c.JSON(http.StatusOK, res)
+}
+
+// ExportCourses GET /api/courses/export 科目の一括出力(format=csv|json)
+func (h *handlers) ExportCourses(c echo.Context) error {
+	records, err := h.exportCourses(c.QueryParam("term_id"))
+	if err != nil {
+		c.Logger().Error(err)
+		return c.NoContent(http.StatusInternalServerError)
+	}
+
+	if c.QueryParam("format") == "csv" {
+		c.Response().Header().Set(echo.HeaderContentType, "text/csv; charset=utf-8")
+		c.Response().Header().Set(echo.HeaderContentDisposition, "attachment; filename=\"courses.csv\"")
+		c.Response().WriteHeader(http.StatusOK)
+		return writeCourseCSV(c.Response(), records)
+	}
+	return c.JSON(http.StatusOK, records)
+}
+
+// isCourseCommand runCourseCommandで扱うサブコマンドか
+func isCourseCommand(name string) bool {
+	return name == "import-courses" || name == "export-courses"
+}
+
+// runCourseCommand サーバーを起動せずに科目の一括登録・出力を行う
+//
+//	isucholar import-courses [--dry-run] <file.csv|file.json>
+//	isucholar export-courses [csv|json] [term_id]
+//
+// DBに直接書き込むので、稼働中のサーバーのキャッシュやETagは更新されない。稼働中はAPIを使うこと
+func runCourseCommand(args []string) int {
+	db, err := GetDB(false)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	defer db.Close()
+	subdb, err := GetSubDB(false)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	defer subdb.Close()
+	h := &handlers{
+		DB:    db,
+		SubDB: subdb,
+	}
+
+	switch args[0] {
+	case "import-courses":
+		dryRun := false
+		if len(args) > 1 && args[1] == "--dry-run" {
+			dryRun = true
+			args = args[1:]
+		}
+		if len(args) != 2 {
+			fmt.Fprintln(os.Stderr, "usage: isucholar import-courses [--dry-run] <file.csv|file.json>")
+			return 2
+		}
+		f, err := os.Open(args[1])
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+		defer f.Close()
+
+		format := "json"
+		if strings.HasSuffix(strings.ToLower(args[1]), ".csv") {
+			format = "csv"
+		}
+		records, rowErrors, err := parseCourseRecords(f, format)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+		res, err := h.importCourses(records, rowErrors, "", dryRun)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+		for _, e := range res.Errors {
+			fmt.Fprintf(os.Stderr, "row %d (%s): %s\n", e.Row, e.Code, e.Message)
+		}
+		if len(res.Errors) > 0 {
+			return 1
+		}
+		fmt.Printf("imported: %d, skipped: %d\n", res.Imported, res.Skipped)
+		return 0
+
+	case "export-courses":
+		format, termID := "json", ""
+		if len(args) > 1 {
+			format = args[1]
+		}
+		if len(args) > 2 {
+			termID = args[2]
+		}
+		records, err := h.exportCourses(termID)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+		if format == "csv" {
+			err = writeCourseCSV(os.Stdout, records)
+		} else {
+			err = json.NewEncoder(os.Stdout).Encode(records)
+		}
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+		return 0
+	}
+
+	fmt.Fprintln(os.Stderr, "unknown command: "+args[0])
+	return 2
+}
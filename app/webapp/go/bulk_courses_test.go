@@ -0,0 +1,128 @@
+package main
+
+import (
+	"bytes"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseCourseCSV(t *testing.T) {
+	input := "\ufeffcode, type ,name,credit,slots,teacher_code,co_teacher_codes,capacity,prerequisites,unknown\n" +
+		"L0001,liberal-arts,微分積分, 2 ,monday:1; wednesday:2,T00001,T00002;T00003,30,M0001:60;M0002,x\n" +
+		"L0002,major-subjects,線形代数,2,tuesday:3,T00001\n"
+	records, rowErrors, err := parseCourseCSV(strings.NewReader(input))
+	if err != nil {
+		t.Fatalf("parseCourseCSV() error = %v", err)
+	}
+	if len(rowErrors) != 0 {
+		t.Errorf("rowErrors = %#v, want none", rowErrors)
+	}
+
+	want := []CourseRecord{
+		{
+			Code:           "L0001",
+			Type:           LiberalArts,
+			Name:           "微分積分",
+			Credit:         2,
+			Slots:          []CourseSlot{{DayOfWeek: Monday, Period: 1}, {DayOfWeek: Wednesday, Period: 2}},
+			TeacherCode:    "T00001",
+			CoTeacherCodes: []string{"T00002", "T00003"},
+			Capacity:       30,
+			Prerequisites:  []Prerequisite{{Code: "M0001", MinTotalScore: 60}, {Code: "M0002"}},
+		},
+		{
+			Code:        "L0002",
+			Type:        MajorSubjects,
+			Name:        "線形代数",
+			Credit:      2,
+			Slots:       []CourseSlot{{DayOfWeek: Tuesday, Period: 3}},
+			TeacherCode: "T00001",
+		},
+	}
+	if !reflect.DeepEqual(records, want) {
+		t.Errorf("records = %#v, want %#v", records, want)
+	}
+}
+
+func TestParseCourseCSVRowErrors(t *testing.T) {
+	input := "code,type,name,credit,slots,teacher_code,capacity,prerequisites\n" +
+		"C1,liberal-arts,a,two,monday:1,T00001,,\n" +
+		"C2,liberal-arts,b,2,monday:1,T00001,many,\n" +
+		"C3,liberal-arts,c,2,monday,T00001,,\n" +
+		"C4,liberal-arts,d,2,monday:0,T00001,,\n" +
+		"C5,liberal-arts,e,2,monday:256,T00001,,\n" +
+		"C6,liberal-arts,f,2,monday:1,T00001,,M0001:high\n" +
+		"C7,liberal-arts,g,2,monday:1,T00001,,\n"
+	records, rowErrors, err := parseCourseCSV(strings.NewReader(input))
+	if err != nil {
+		t.Fatalf("parseCourseCSV() error = %v", err)
+	}
+	if len(records) != 7 {
+		t.Errorf("len(records) = %d, want 7", len(records))
+	}
+
+	want := []ImportRowError{
+		{Row: 1, Code: "C1", Message: "Invalid credit."},
+		{Row: 2, Code: "C2", Message: "Invalid capacity."},
+		{Row: 3, Code: "C3", Message: "Invalid slots."},
+		{Row: 4, Code: "C4", Message: "Invalid slots."},
+		{Row: 5, Code: "C5", Message: "Invalid slots."},
+		{Row: 6, Code: "C6", Message: "Invalid prerequisites."},
+	}
+	if !reflect.DeepEqual(rowErrors, want) {
+		t.Errorf("rowErrors = %#v, want %#v", rowErrors, want)
+	}
+}
+
+func TestParseCourseCSVHeader(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+	}{
+		{"empty", ""},
+		{"missing column", "code,type,name,credit,slots\nC1,liberal-arts,a,2,monday:1\n"},
+		{"malformed", "code,type,name,credit,slots,teacher_code\n\"C1,liberal-arts,a,2,monday:1,T00001\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, _, err := parseCourseCSV(strings.NewReader(tt.input)); err == nil {
+				t.Errorf("parseCourseCSV() error = nil, want error")
+			}
+		})
+	}
+}
+
+func TestCourseCSVRoundTrip(t *testing.T) {
+	records := []CourseRecord{
+		{
+			Code:           "L0001",
+			Type:           LiberalArts,
+			Name:           "微分積分, 基礎",
+			Description:    "複数行の\n説明",
+			Credit:         2,
+			Slots:          []CourseSlot{{DayOfWeek: Monday, Period: 1}, {DayOfWeek: Friday, Period: 6}},
+			TeacherCode:    "T00001",
+			CoTeacherCodes: []string{"T00002"},
+			Keywords:       "数学 解析",
+			TermID:         "01FF4RXEKS0DG2EG20CWPQ60M3",
+			Capacity:       30,
+			Prerequisites:  []Prerequisite{{Code: "M0001", MinTotalScore: 60}},
+			Status:         StatusInProgress,
+		},
+	}
+	var buf bytes.Buffer
+	if err := writeCourseCSV(&buf, records); err != nil {
+		t.Fatalf("writeCourseCSV() error = %v", err)
+	}
+	got, rowErrors, err := parseCourseCSV(&buf)
+	if err != nil {
+		t.Fatalf("parseCourseCSV() error = %v", err)
+	}
+	if len(rowErrors) != 0 {
+		t.Errorf("rowErrors = %#v, want none", rowErrors)
+	}
+	if !reflect.DeepEqual(got, records) {
+		t.Errorf("parseCourseCSV(writeCourseCSV()) = %#v, want %#v", got, records)
+	}
+}
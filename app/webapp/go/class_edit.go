@@ -0,0 +1,331 @@
+package main
+
+import (
+	"database/sql"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/labstack/echo/v4"
+)
+
+// checkClassStaff 講義が指定された科目のものであり、操作する教員がその科目の担当であることを確認する
+// 問題がなければ講義とtrueを返す。問題があればエラーのレスポンスを書き込んでfalseを返す
+func (h *handlers) checkClassStaff(c echo.Context, courseID string, classID string) (*Class, bool) {
+	userID, _, _, err := getUserInfo(c)
+	if err != nil {
+		c.Logger().Error(err)
+		c.NoContent(http.StatusInternalServerError)
+		return nil, false
+	}
+
+	if ok, _ := h.getCourse(courseID); !ok {
+		c.String(http.StatusNotFound, "No such course.")
+		return nil, false
+	}
+	ok, class := h.getClass(classID)
+	if !ok || class.CourseID != courseID {
+		c.String(http.StatusNotFound, "No such class.")
+		return nil, false
+	}
+	isStaff, err := h.isCourseStaff(courseID, userID)
+	if err != nil {
+		c.Logger().Error(err)
+		c.NoContent(http.StatusInternalServerError)
+		return nil, false
+	}
+	if !isStaff {
+		c.String(http.StatusForbidden, "You are not the teacher of this course.")
+		return nil, false
+	}
+	return class, true
+}
+
+type UpdateClassRequest struct {
+	Title       *string `json:"title"`
+	Description *string `json:"description"`
+	// 空文字列の場合は実施日を未定にする
+	HeldOn *string `json:"held_on"`
+	// 提出期限(RFC3339)。空文字列の場合は期限をなくす。締め切り済みの講義の再開はOpenSubmissionで行う
+	Deadline *string `json:"deadline"`
+	LatePolicyRequest
+}
+
+// UpdateClass PATCH /api/courses/:courseID/classes/:classID 講義の題名・説明・実施日・提出期限・遅延提出の設定の更新
+func (h *handlers) UpdateClass(c echo.Context) error {
+	courseID := c.Param("courseID")
+	classID := c.Param("classID")
+
+	var req UpdateClassRequest
+	if err := c.Bind(&req); err != nil {
+		return c.String(http.StatusBadRequest, "Invalid format.")
+	}
+
+	current, ok := h.checkClassStaff(c, courseID, classID)
+	if !ok {
+		return nil
+	}
+
+	class := *current
+	if req.Title != nil {
+		if *req.Title == "" || len(*req.Title) > 255 {
+			return c.String(http.StatusBadRequest, "Invalid title.")
+		}
+		class.Title = *req.Title
+	}
+	if req.Description != nil {
+		class.Description = *req.Description
+	}
+	if req.HeldOn != nil {
+		class.HeldOn = sql.NullTime{}
+		if *req.HeldOn != "" {
+			d, err := time.Parse(dateFormat, *req.HeldOn)
+			if err != nil {
+				return c.String(http.StatusBadRequest, "Invalid date.")
+			}
+			class.HeldOn = sql.NullTime{Time: d, Valid: true}
+		}
+	}
+	if req.Deadline != nil {
+		deadline, err := parseDeadline(*req.Deadline)
+		if err != nil {
+			return c.String(http.StatusBadRequest, "Invalid deadline.")
+		}
+		class.Deadline = deadline
+	}
+	if !req.LatePolicyRequest.apply(&class) {
+		return c.String(http.StatusBadRequest, "Invalid late policy.")
+	}
+	// 過ぎた期限を設定した場合も、延長された学生がいるかもしれないので締め切りはスケジューラに任せる
+	query := "UPDATE `classes` SET `title` = ?, `description` = ?, `held_on` = ?, `deadline` = ?," +
+		" `grace_period` = ?, `late_policy` = ?, `late_penalty_rate` = ?, `late_penalty_cap` = ? WHERE `id` = ?"
+	args := []interface{}{class.Title, class.Description, class.HeldOn, class.Deadline,
+		class.GracePeriod, class.LatePolicy, class.LatePenaltyRate, class.LatePenaltyCap, classID}
+
+	tx, err := h.DB.Beginx()
+	if err != nil {
+		c.Logger().Error(err)
+		return c.NoContent(http.StatusInternalServerError)
+	}
+	defer tx.Rollback()
+
+	h.SubDB.Exec(query, args...)
+	if _, err := tx.Exec(query, args...); err != nil {
+		c.Logger().Error(err)
+		return c.NoContent(http.StatusInternalServerError)
+	}
+
+	// 期限や遅延提出の規則が変わると減点が変わるので合計点を計算し直す
+	rescore := class.Deadline.Valid != current.Deadline.Valid || !class.Deadline.Time.Equal(current.Deadline.Time) ||
+		class.GracePeriod != current.GracePeriod ||
+		class.LatePolicy != current.LatePolicy || class.LatePenaltyRate != current.LatePenaltyRate ||
+		class.LatePenaltyCap != current.LatePenaltyCap
+	if rescore {
+		if err := h.updateTotalScores(tx, courseID); err != nil {
+			c.Logger().Error(err)
+			return c.NoContent(http.StatusInternalServerError)
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		c.Logger().Error(err)
+		return c.NoContent(http.StatusInternalServerError)
+	}
+
+	ClassCacheMux.Lock()
+	ClassCacheMap[classID] = &class
+	ClassCacheMux.Unlock()
+
+	if rescore {
+		bumpVersion(classesKey(courseID), gradesKey)
+	} else {
+		bumpVersion(classesKey(courseID))
+	}
+	return c.NoContent(http.StatusOK)
+}
+
+type ReorderClassesRequest struct {
+	// 科目のすべての講義のIDを新しい順序で指定する。partは1から振り直す
+	ClassIDs []string `json:"class_ids"`
+}
+
+// ReorderClasses PUT /api/courses/:courseID/classes/order 講義の回(part)の振り直し
+func (h *handlers) ReorderClasses(c echo.Context) error {
+	userID, _, _, err := getUserInfo(c)
+	if err != nil {
+		c.Logger().Error(err)
+		return c.NoContent(http.StatusInternalServerError)
+	}
+
+	courseID := c.Param("courseID")
+
+	var req ReorderClassesRequest
+	if err := c.Bind(&req); err != nil {
+		return c.String(http.StatusBadRequest, "Invalid format.")
+	}
+
+	if ok, _ := h.getCourse(courseID); !ok {
+		return c.String(http.StatusNotFound, "No such course.")
+	}
+	isStaff, err := h.isCourseStaff(courseID, userID)
+	if err != nil {
+		c.Logger().Error(err)
+		return c.NoContent(http.StatusInternalServerError)
+	}
+	if !isStaff {
+		return c.String(http.StatusForbidden, "You are not the teacher of this course.")
+	}
+
+	tx, err := h.DB.Beginx()
+	if err != nil {
+		c.Logger().Error(err)
+		return c.NoContent(http.StatusInternalServerError)
+	}
+	defer tx.Rollback()
+
+	var classes []Class
+	if err := tx.Select(&classes, "SELECT * FROM `classes` WHERE `course_id` = ? FOR UPDATE", courseID); err != nil {
+		c.Logger().Error(err)
+		return c.NoContent(http.StatusInternalServerError)
+	}
+	current := make(map[string]*Class, len(classes))
+	for i := range classes {
+		current[classes[i].ID] = &classes[i]
+	}
+	if len(req.ClassIDs) != len(classes) {
+		return c.String(http.StatusBadRequest, "All classes of the course must be specified.")
+	}
+	seen := make(map[string]bool, len(req.ClassIDs))
+	for _, classID := range req.ClassIDs {
+		if current[classID] == nil || seen[classID] {
+			return c.String(http.StatusBadRequest, "All classes of the course must be specified.")
+		}
+		seen[classID] = true
+	}
+
+	// (course_id, part)の一意制約に掛からないよう、現在とも変更後とも重ならない値を経由して振り直す
+	used := make(map[int]bool, len(classes)*2)
+	for i := range classes {
+		used[int(classes[i].Part)] = true
+		used[i+1] = true
+	}
+	var temporary []uint8
+	for part := 255; part > 0 && len(temporary) < len(classes); part-- {
+		if !used[part] {
+			temporary = append(temporary, uint8(part))
+		}
+	}
+	if len(temporary) < len(classes) {
+		return c.String(http.StatusBadRequest, "Too many classes to reorder.")
+	}
+
+	query := "UPDATE `classes` SET `part` = ? WHERE `id` = ?"
+	for i, classID := range req.ClassIDs {
+		h.SubDB.Exec(query, temporary[i], classID)
+		if _, err := tx.Exec(query, temporary[i], classID); err != nil {
+			c.Logger().Error(err)
+			return c.NoContent(http.StatusInternalServerError)
+		}
+	}
+	for i, classID := range req.ClassIDs {
+		h.SubDB.Exec(query, i+1, classID)
+		if _, err := tx.Exec(query, i+1, classID); err != nil {
+			c.Logger().Error(err)
+			return c.NoContent(http.StatusInternalServerError)
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		c.Logger().Error(err)
+		return c.NoContent(http.StatusInternalServerError)
+	}
+
+	ClassCacheMux.Lock()
+	for i, classID := range req.ClassIDs {
+		class := *current[classID]
+		class.Part = uint8(i + 1)
+		ClassCacheMap[classID] = &class
+	}
+	ClassCacheMux.Unlock()
+
+	bumpVersion(classesKey(courseID))
+	return c.NoContent(http.StatusOK)
+}
+
+// DeleteClass DELETE /api/courses/:courseID/classes/:classID 提出物のない講義の削除
+func (h *handlers) DeleteClass(c echo.Context) error {
+	courseID := c.Param("courseID")
+	classID := c.Param("classID")
+
+	_, ok := h.checkClassStaff(c, courseID, classID)
+	if !ok {
+		return nil
+	}
+
+	tx, err := h.DB.Beginx()
+	if err != nil {
+		c.Logger().Error(err)
+		return c.NoContent(http.StatusInternalServerError)
+	}
+	defer tx.Rollback()
+
+	// SubmitAssignmentは講義の行を共有ロックで読みながら提出物を登録するので、排他ロックを取れば提出の途中ではない
+	// 提出物はロック読み取りで数え、ロックを待つ間にコミットされた提出物も数える
+	if _, err := tx.Exec("SELECT 1 FROM `classes` WHERE `id` = ? FOR UPDATE", classID); err != nil {
+		c.Logger().Error(err)
+		return c.NoContent(http.StatusInternalServerError)
+	}
+	var count int
+	if err := tx.Get(&count, "SELECT COUNT(*) FROM `submissions` WHERE `class_id` = ? FOR UPDATE", classID); err != nil {
+		c.Logger().Error(err)
+		return c.NoContent(http.StatusInternalServerError)
+	}
+	if count > 0 {
+		return c.String(http.StatusConflict, "This class has submissions.")
+	}
+	var materialIDs []string
+	if err := tx.Select(&materialIDs, "SELECT `id` FROM `class_materials` WHERE `class_id` = ?", classID); err != nil {
+		c.Logger().Error(err)
+		return c.NoContent(http.StatusInternalServerError)
+	}
+
+	for _, query := range []string{
+		"DELETE FROM `class_materials` WHERE `class_id` = ?",
+		"DELETE FROM `deadline_extensions` WHERE `class_id` = ?",
+		"DELETE FROM `attendances` WHERE `class_id` = ?",
+		"DELETE `attendance_attempts` FROM `attendance_attempts` JOIN `attendance_sessions` ON `attendance_sessions`.`id` = `attendance_attempts`.`session_id` WHERE `attendance_sessions`.`class_id` = ?",
+		"DELETE FROM `attendance_sessions` WHERE `class_id` = ?",
+		"DELETE FROM `classes` WHERE `id` = ?",
+	} {
+		h.SubDB.Exec(query, classID)
+		if _, err := tx.Exec(query, classID); err != nil {
+			c.Logger().Error(err)
+			return c.NoContent(http.StatusInternalServerError)
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		c.Logger().Error(err)
+		return c.NoContent(http.StatusInternalServerError)
+	}
+
+	if err := os.Remove(AssignmentsDirectory + classID + ".zip"); err != nil && !os.IsNotExist(err) {
+		c.Logger().Error(err)
+	}
+	for _, materialID := range materialIDs {
+		if err := os.Remove(materialPath(classID, materialID)); err != nil && !os.IsNotExist(err) {
+			c.Logger().Error(err)
+		}
+	}
+
+	ClassCacheMux.Lock()
+	delete(ClassCacheMap, classID)
+	ClassCacheMux.Unlock()
+
+	classToCourseMapMutex.Lock()
+	delete(classToCourseMap, classID)
+	classToCourseMapMutex.Unlock()
+
+	bumpVersion(classesKey(courseID), gradesKey)
+	return c.NoContent(http.StatusNoContent)
+}
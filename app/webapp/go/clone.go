@@ -0,0 +1,194 @@
+package main
+
+import (
+	"database/sql"
+	"net/http"
+
+	"github.com/go-sql-driver/mysql"
+	"github.com/labstack/echo/v4"
+)
+
+type CloneCourseRequest struct {
+	Code string `json:"code"`
+	// 省略時は元の科目と同じ学期
+	TermID         *string `json:"term_id"`
+	IncludeClasses bool    `json:"include_classes"`
+	// 省略時は共同担当の教員も引き継ぐ
+	IncludeStaff *bool `json:"include_staff"`
+}
+
+// CloneCourse POST /api/courses/:courseID/clone 科目を複製して新しい科目(registration)を作成
+// 履修登録・提出物・成績は引き継がない。複製した教員がownerになり、元の担当教員は共同担当になる
+func (h *handlers) CloneCourse(c echo.Context) error {
+	userID, _, _, err := getUserInfo(c)
+	if err != nil {
+		c.Logger().Error(err)
+		return c.NoContent(http.StatusInternalServerError)
+	}
+
+	courseID := c.Param("courseID")
+
+	var req CloneCourseRequest
+	if err := c.Bind(&req); err != nil {
+		return c.String(http.StatusBadRequest, "Invalid format.")
+	}
+
+	ok, src := h.getCourse(courseID)
+	if !ok {
+		return c.String(http.StatusNotFound, "No such course.")
+	}
+	isStaff, err := h.isCourseStaff(courseID, userID)
+	if err != nil {
+		c.Logger().Error(err)
+		return c.NoContent(http.StatusInternalServerError)
+	}
+	if !isStaff {
+		return c.String(http.StatusForbidden, "You are not the teacher of this course.")
+	}
+
+	if req.Code == "" || len(req.Code) > 255 || req.Code == src.Code {
+		return c.String(http.StatusBadRequest, "Invalid course code.")
+	}
+	termID := src.TermID
+	if req.TermID != nil {
+		termID = sql.NullString{String: *req.TermID, Valid: *req.TermID != ""}
+		if termID.Valid {
+			if ok, _ := h.getTerm(termID.String); !ok {
+				return c.String(http.StatusBadRequest, "No such term.")
+			}
+		}
+	}
+
+	slots, err := h.getCourseSlots(src.ID, src.primarySlot())
+	if err != nil {
+		c.Logger().Error(err)
+		return c.NoContent(http.StatusInternalServerError)
+	}
+	prerequisites, err := h.getPrerequisites(src.ID)
+	if err != nil {
+		c.Logger().Error(err)
+		return c.NoContent(http.StatusInternalServerError)
+	}
+	// 前提科目が複製後の科目自身になる場合は除く
+	clonedPrerequisites := make([]Prerequisite, 0, len(prerequisites))
+	for _, p := range prerequisites {
+		if p.Code != req.Code {
+			clonedPrerequisites = append(clonedPrerequisites, p)
+		}
+	}
+	staff, err := h.getCourseStaff(src.ID)
+	if err != nil {
+		c.Logger().Error(err)
+		return c.NoContent(http.StatusInternalServerError)
+	}
+
+	course := &Course{
+		ID:          newULID(),
+		Code:        req.Code,
+		Type:        src.Type,
+		Name:        src.Name,
+		Description: src.Description,
+		Credit:      src.Credit,
+		Period:      src.Period,
+		DayOfWeek:   src.DayOfWeek,
+		TeacherID:   userID,
+		Keywords:    src.Keywords,
+		Status:      StatusRegistration,
+		TermID:      termID,
+		Capacity:    src.Capacity,
+	}
+
+	tx, err := h.DB.Beginx()
+	if err != nil {
+		c.Logger().Error(err)
+		return c.NoContent(http.StatusInternalServerError)
+	}
+	defer tx.Rollback()
+
+	query := "INSERT INTO `courses` (`id`, `code`, `type`, `name`, `description`, `credit`, `period`, `day_of_week`, `teacher_id`, `keywords`, `term_id`, `capacity`) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)"
+	h.SubDB.Exec(query, course.ID, course.Code, course.Type, course.Name, course.Description, course.Credit, course.Period, course.DayOfWeek, course.TeacherID, course.Keywords, course.TermID, course.Capacity)
+	if _, err := tx.Exec(query, course.ID, course.Code, course.Type, course.Name, course.Description, course.Credit, course.Period, course.DayOfWeek, course.TeacherID, course.Keywords, course.TermID, course.Capacity); err != nil {
+		if mysqlErr, ok := err.(*mysql.MySQLError); ok && mysqlErr.Number == uint16(mysqlErrNumDuplicateEntry) {
+			return c.String(http.StatusConflict, "A course with the same code already exists.")
+		}
+		c.Logger().Error(err)
+		return c.NoContent(http.StatusInternalServerError)
+	}
+
+	if err := h.insertPrerequisites(tx, h.SubDB, course.ID, clonedPrerequisites); err != nil {
+		c.Logger().Error(err)
+		return c.NoContent(http.StatusInternalServerError)
+	}
+	if err := h.insertCourseSlots(tx, h.SubDB, course.ID, slots); err != nil {
+		c.Logger().Error(err)
+		return c.NoContent(http.StatusInternalServerError)
+	}
+	if err := h.insertCourseStaff(tx, h.SubDB, course.ID, userID, RoleOwner); err != nil {
+		c.Logger().Error(err)
+		return c.NoContent(http.StatusInternalServerError)
+	}
+	if _, err := h.addSyllabusRevision(tx, h.SubDB, course, userID); err != nil {
+		c.Logger().Error(err)
+		return c.NoContent(http.StatusInternalServerError)
+	}
+	if req.IncludeStaff == nil || *req.IncludeStaff {
+		for _, s := range staff {
+			if s.UserID == userID {
+				continue
+			}
+			if err := h.insertCourseStaff(tx, h.SubDB, course.ID, s.UserID, RoleCoTeacher); err != nil {
+				c.Logger().Error(err)
+				return c.NoContent(http.StatusInternalServerError)
+			}
+		}
+	}
+
+	// 講義は内容と遅延提出の規則のみ複製する。実施日と提出期限は新しい学期では異なるので引き継がない
+	var classes []Class
+	if req.IncludeClasses {
+		if err := tx.Select(&classes, "SELECT * FROM `classes` WHERE `course_id` = ? ORDER BY `part`", src.ID); err != nil {
+			c.Logger().Error(err)
+			return c.NoContent(http.StatusInternalServerError)
+		}
+		for i := range classes {
+			classes[i] = Class{
+				ID:              newULID(),
+				CourseID:        course.ID,
+				Part:            classes[i].Part,
+				Title:           classes[i].Title,
+				Description:     classes[i].Description,
+				GracePeriod:     classes[i].GracePeriod,
+				LatePolicy:      classes[i].LatePolicy,
+				LatePenaltyRate: classes[i].LatePenaltyRate,
+				LatePenaltyCap:  classes[i].LatePenaltyCap,
+			}
+			class := &classes[i]
+			query := "INSERT INTO `classes` (`id`, `course_id`, `part`, `title`, `description`, `grace_period`, `late_policy`, `late_penalty_rate`, `late_penalty_cap`) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)"
+			h.SubDB.Exec(query, class.ID, class.CourseID, class.Part, class.Title, class.Description, class.GracePeriod, class.LatePolicy, class.LatePenaltyRate, class.LatePenaltyCap)
+			if _, err := tx.Exec(query, class.ID, class.CourseID, class.Part, class.Title, class.Description, class.GracePeriod, class.LatePolicy, class.LatePenaltyRate, class.LatePenaltyCap); err != nil {
+				c.Logger().Error(err)
+				return c.NoContent(http.StatusInternalServerError)
+			}
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		c.Logger().Error(err)
+		return c.NoContent(http.StatusInternalServerError)
+	}
+
+	cachePrerequisites(course.ID, clonedPrerequisites)
+	cacheCourseSlots(course.ID, slots)
+	discardCourseStaff(course.ID)
+	CourseCacheMux.Lock()
+	CourseCacheMap[course.ID] = course
+	CourseCacheMux.Unlock()
+	ClassCacheMux.Lock()
+	for i := range classes {
+		ClassCacheMap[classes[i].ID] = &classes[i]
+	}
+	ClassCacheMux.Unlock()
+
+	bumpVersion(catalogKey, courseKey(course.ID), classesKey(course.ID))
+	return c.JSON(http.StatusCreated, AddCourseResponse{ID: course.ID})
+}
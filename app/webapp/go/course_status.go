@@ -0,0 +1,108 @@
+package main
+
+import (
+	"github.com/jmoiron/sqlx"
+)
+
+// 科目ステータスの状態遷移
+// registration -> in-progress -> closed の順にのみ進められる
+// closed -> in-progress の再開は明示的に指定された場合のみ許可する
+var courseStatusTransitions = map[CourseStatus][]CourseStatus{
+	StatusRegistration: {StatusInProgress},
+	StatusInProgress:   {StatusClosed},
+	StatusClosed:       {},
+}
+
+var courseStatusReopenTransitions = map[CourseStatus][]CourseStatus{
+	StatusClosed: {StatusInProgress},
+}
+
+func isValidCourseStatus(status CourseStatus) bool {
+	_, ok := courseStatusTransitions[status]
+	return ok
+}
+
+func canTransitCourseStatus(from CourseStatus, to CourseStatus, reopen bool) bool {
+	transitions := courseStatusTransitions
+	if reopen {
+		transitions = courseStatusReopenTransitions
+	}
+	for _, next := range transitions[from] {
+		if next == to {
+			return true
+		}
+	}
+	return false
+}
+
+// courseStatusHook ステータス遷移時にトランザクション内で実行される処理
+// SubDBへの書き込みも各hookで行う
+type courseStatusHook func(h *handlers, tx *sqlx.Tx, course *Course) error
+
+// courseStatusAfterHook コミット後に実行されるキャッシュの更新などの処理
+type courseStatusAfterHook func(h *handlers, course *Course)
+
+var (
+	courseStatusHooks = map[CourseStatus][]courseStatusHook{
+		StatusInProgress: {clearWaitlistHook},
+		StatusClosed:     {closeClassSubmissionsHook, finalizeTotalScoresHook},
+	}
+	courseStatusAfterHooks = map[CourseStatus][]courseStatusAfterHook{
+		StatusClosed: {closeClassSubmissionsAfterHook},
+	}
+)
+
+func (h *handlers) runCourseStatusHooks(tx *sqlx.Tx, course *Course, to CourseStatus) error {
+	for _, hook := range courseStatusHooks[to] {
+		if err := hook(h, tx, course); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (h *handlers) runCourseStatusAfterHooks(course *Course, to CourseStatus) {
+	for _, hook := range courseStatusAfterHooks[to] {
+		hook(h, course)
+	}
+}
+
+// closeClassSubmissionsHook 科目の終了時にまだ締め切られていない講義の課題提出を締め切る
+func closeClassSubmissionsHook(h *handlers, tx *sqlx.Tx, course *Course) error {
+	query := "UPDATE `classes` SET `submission_closed` = true WHERE `course_id` = ? AND `submission_closed` = false"
+	if _, err := h.SubDB.Exec(query, course.ID); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(query, course.ID); err != nil {
+		return err
+	}
+	return nil
+}
+
+func closeClassSubmissionsAfterHook(h *handlers, course *Course) {
+	ClassCacheMux.Lock()
+	for _, class := range ClassCacheMap {
+		if class.CourseID == course.ID {
+			class.SubmissionClosed = true
+		}
+	}
+	ClassCacheMux.Unlock()
+
+	bumpVersion(classesKey(course.ID))
+}
+
+// finalizeTotalScoresHook 科目の終了時に成績を確定させる
+func finalizeTotalScoresHook(h *handlers, tx *sqlx.Tx, course *Course) error {
+	return h.updateTotalScores(tx, course.ID)
+}
+
+// clearWaitlistHook 履修登録期間が終わったらキャンセル待ちは不要になる
+func clearWaitlistHook(h *handlers, tx *sqlx.Tx, course *Course) error {
+	if _, err := h.SubDB.Exec("DELETE FROM `waitlists` WHERE `course_id` = ?", course.ID); err != nil {
+		return err
+	}
+	if _, err := tx.Exec("DELETE FROM `waitlists` WHERE `course_id` = ?", course.ID); err != nil {
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"sort"
+	"strconv"
+)
+
+// 履修登録時の単位数の制約。環境変数で設定し、0は制約なし
+// 下限の制約は時間割全体を指定する mode=replace の時のみ検査する
+type creditRules struct {
+	MaxCreditsPerTerm     int
+	MinLiberalArtsCredits int
+	MaxLiberalArtsCredits int
+	MinMajorCredits       int
+	MaxMajorCredits       int
+}
+
+func getEnvInt(key string, val int) int {
+	v, err := strconv.Atoi(GetEnv(key, ""))
+	if err != nil {
+		return val
+	}
+	return v
+}
+
+var registrationCreditRules = creditRules{
+	MaxCreditsPerTerm:     getEnvInt("MAX_CREDITS_PER_TERM", 0),
+	MinLiberalArtsCredits: getEnvInt("MIN_LIBERAL_ARTS_CREDITS", 0),
+	MaxLiberalArtsCredits: getEnvInt("MAX_LIBERAL_ARTS_CREDITS", 0),
+	MinMajorCredits:       getEnvInt("MIN_MAJOR_SUBJECTS_CREDITS", 0),
+	MaxMajorCredits:       getEnvInt("MAX_MAJOR_SUBJECTS_CREDITS", 0),
+}
+
+type CreditRuleViolation struct {
+	Rule   string  `json:"rule"`
+	TermID *string `json:"term_id"`
+	Limit  int     `json:"limit"`
+	Actual int     `json:"actual"`
+}
+
+const (
+	RuleMaxCreditsPerTerm     = "max_credits_per_term"
+	RuleMinLiberalArtsCredits = "min_liberal_arts_credits"
+	RuleMaxLiberalArtsCredits = "max_liberal_arts_credits"
+	RuleMinMajorCredits       = "min_major_subjects_credits"
+	RuleMaxMajorCredits       = "max_major_subjects_credits"
+)
+
+// check 履修済みの科目と追加する科目を合わせた時間割を学期毎に検査する
+// 学期が設定されていない科目は一つの学期としてまとめて扱う
+func (r creditRules) check(courses []Course, checkMin bool) []CreditRuleViolation {
+	type creditsS struct {
+		total       int
+		liberalArts int
+		major       int
+	}
+	byTerm := map[string]*creditsS{}
+	for _, course := range courses {
+		credits, ok := byTerm[course.TermID.String]
+		if !ok {
+			credits = &creditsS{}
+			byTerm[course.TermID.String] = credits
+		}
+		credits.total += int(course.Credit)
+		switch course.Type {
+		case LiberalArts:
+			credits.liberalArts += int(course.Credit)
+		case MajorSubjects:
+			credits.major += int(course.Credit)
+		}
+	}
+
+	termIDs := make([]string, 0, len(byTerm))
+	for termID := range byTerm {
+		termIDs = append(termIDs, termID)
+	}
+	sort.Strings(termIDs)
+
+	var violations []CreditRuleViolation
+	for _, termID := range termIDs {
+		credits := byTerm[termID]
+		var id *string
+		if termID != "" {
+			id = new(string)
+			*id = termID
+		}
+		add := func(rule string, limit int, actual int) {
+			violations = append(violations, CreditRuleViolation{Rule: rule, TermID: id, Limit: limit, Actual: actual})
+		}
+
+		if r.MaxCreditsPerTerm > 0 && credits.total > r.MaxCreditsPerTerm {
+			add(RuleMaxCreditsPerTerm, r.MaxCreditsPerTerm, credits.total)
+		}
+		if r.MaxLiberalArtsCredits > 0 && credits.liberalArts > r.MaxLiberalArtsCredits {
+			add(RuleMaxLiberalArtsCredits, r.MaxLiberalArtsCredits, credits.liberalArts)
+		}
+		if r.MaxMajorCredits > 0 && credits.major > r.MaxMajorCredits {
+			add(RuleMaxMajorCredits, r.MaxMajorCredits, credits.major)
+		}
+		if checkMin {
+			if r.MinLiberalArtsCredits > 0 && credits.liberalArts < r.MinLiberalArtsCredits {
+				add(RuleMinLiberalArtsCredits, r.MinLiberalArtsCredits, credits.liberalArts)
+			}
+			if r.MinMajorCredits > 0 && credits.major < r.MinMajorCredits {
+				add(RuleMinMajorCredits, r.MinMajorCredits, credits.major)
+			}
+		}
+	}
+	return violations
+}
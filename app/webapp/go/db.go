@@ -1,6 +1,9 @@
 package main
 
 import (
+	"database/sql"
+	"database/sql/driver"
+
 	"github.com/go-sql-driver/mysql"
 	"github.com/jmoiron/sqlx"
 )
@@ -34,3 +37,27 @@ func GetSubDB(batch bool) (*sqlx.DB, error) {
 
 	return sqlx.Open("mysql", mysqlConfig.FormatDSN())
 }
+
+// deferredExecer SubDBへの書き込みを溜めておき、主DBのトランザクションがコミットされた後にflushで流す
+// 途中で失敗した場合にSubDBにだけ書き込みが残らないようにする
+type deferredExecer struct {
+	stmts []deferredStmt
+}
+
+type deferredStmt struct {
+	query string
+	args  []interface{}
+}
+
+func (d *deferredExecer) Exec(query string, args ...interface{}) (sql.Result, error) {
+	d.stmts = append(d.stmts, deferredStmt{query: query, args: args})
+	return driver.RowsAffected(0), nil
+}
+
+// flush 溜めた書き込みを順に流す。他のSubDBへの書き込みと同じくエラーは無視する
+func (d *deferredExecer) flush(db sqlx.Execer) {
+	for _, stmt := range d.stmts {
+		db.Exec(stmt.query, stmt.args...)
+	}
+	d.stmts = nil
+}
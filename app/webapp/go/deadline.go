@@ -0,0 +1,216 @@
+package main
+
+import (
+	"database/sql"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/labstack/echo/v4"
+)
+
+// 課題の提出期限
+// 期限(と遅延提出の猶予期間)を過ぎた講義はスケジューラが定期的に締め切る。締め切りまでの間もSubmitAssignmentは期限で提出を拒否する
+
+const deadlineSchedulerInterval = time.Second
+
+// parseDeadline RFC3339形式の提出期限を解釈する。空文字列は期限なし
+func parseDeadline(s string) (sql.NullTime, error) {
+	if s == "" {
+		return sql.NullTime{}, nil
+	}
+	t, err := time.Parse(time.RFC3339, s)
+	if err != nil {
+		return sql.NullTime{}, err
+	}
+	return sql.NullTime{Time: t.UTC().Truncate(time.Microsecond), Valid: true}, nil
+}
+
+func nullTimePtr(t sql.NullTime) *time.Time {
+	if !t.Valid {
+		return nil
+	}
+	v := t.Time
+	return &v
+}
+
+// isDeadlinePassed 提出期限が設定されていて、猶予期間も含めて過ぎているか
+func (c *Class) isDeadlinePassed(now time.Time) bool {
+	return c.Deadline.Valid && !now.Before(c.closesAt())
+}
+
+// isSubmissionClosed 締め切り済みか、提出期限を過ぎていれば提出を受け付けない
+func (c *Class) isSubmissionClosed(now time.Time) bool {
+	return c.SubmissionClosed || c.isDeadlinePassed(now)
+}
+
+// setSubmissionClosed 講義の課題提出の受付状態を変更する
+func (h *handlers) setSubmissionClosed(db sqlx.Execer, classID string, closed bool, deadline sql.NullTime) error {
+	query := "UPDATE `classes` SET `submission_closed` = ?, `deadline` = ? WHERE `id` = ?"
+	h.SubDB.Exec(query, closed, deadline, classID)
+	if _, err := db.Exec(query, closed, deadline, classID); err != nil {
+		return err
+	}
+	return nil
+}
+
+// runDeadlineScheduler 提出期限を過ぎた講義を締め切り続ける
+// 複数台で動かしても良いように、締め切りの更新は冪等にし、キャッシュは各プロセスで前回からの間に期限を迎えた講義を更新する
+func (h *handlers) runDeadlineScheduler(logger echo.Logger) {
+	var last time.Time
+	ticker := time.NewTicker(deadlineSchedulerInterval)
+	defer ticker.Stop()
+	for now := range ticker.C {
+		if err := h.closeExpiredClasses(last, now); err != nil {
+			logger.Error(err)
+			continue
+		}
+		last = now
+	}
+}
+
+func (h *handlers) closeExpiredClasses(last time.Time, now time.Time) error {
+	var expired []string
+	// 締め切る日時は延長を含めた最も遅い期限 + 猶予期間
+	// 締め切り済みの講義はキャッシュの更新のため、前回以降に締め切る日時を迎えうるものだけを集計する
+	query := "SELECT `id` FROM (" +
+		"     SELECT `classes`.`id`, `classes`.`course_id`, `classes`.`submission_closed`," +
+		"         GREATEST(`classes`.`deadline`, IFNULL(MAX(`deadline_extensions`.`deadline`), `classes`.`deadline`)) + INTERVAL `classes`.`grace_period` SECOND AS `closes_at`" +
+		"     FROM `classes`" +
+		"     LEFT JOIN `deadline_extensions` ON `deadline_extensions`.`class_id` = `classes`.`id`" +
+		"     WHERE `classes`.`deadline` <= ? AND (" +
+		"         `classes`.`submission_closed` = false" +
+		"         OR `classes`.`deadline` + INTERVAL `classes`.`grace_period` SECOND > ?" +
+		"         OR EXISTS (SELECT 1 FROM `deadline_extensions` AS `e` WHERE `e`.`class_id` = `classes`.`id` AND `e`.`deadline` + INTERVAL `classes`.`grace_period` SECOND > ?)" +
+		"     )" +
+		"     GROUP BY `classes`.`id`" +
+		" ) AS `t`" +
+		" WHERE `closes_at` <= ? AND (`submission_closed` = false OR `closes_at` > ?)"
+	if err := h.DB.Select(&expired, query, now, last, last, now, last); err != nil {
+		return err
+	}
+	if len(expired) == 0 {
+		return nil
+	}
+
+	args := make([]interface{}, 0, len(expired)+2)
+	for _, classID := range expired {
+		args = append(args, classID)
+	}
+	in := "(?" + strings.Repeat(", ?", len(expired)-1) + ")"
+	// SELECTの後に期限が延長・変更されていれば締め切らないよう、更新時にも締め切る日時を確認する
+	query = "UPDATE `classes` SET `submission_closed` = true" +
+		" WHERE `id` IN " + in + " AND `submission_closed` = false" +
+		" AND `deadline` + INTERVAL `grace_period` SECOND <= ?" +
+		" AND NOT EXISTS (SELECT 1 FROM `deadline_extensions` WHERE `deadline_extensions`.`class_id` = `classes`.`id` AND `deadline_extensions`.`deadline` + INTERVAL `classes`.`grace_period` SECOND > ?)"
+	h.SubDB.Exec(query, append(args, now, now)...)
+	if _, err := h.DB.Exec(query, append(args, now, now)...); err != nil {
+		return err
+	}
+
+	// 実際に締め切られた講義(他のサーバーが締め切ったものを含む)のみキャッシュを更新する
+	var closed []Class
+	if err := h.DB.Select(&closed, "SELECT * FROM `classes` WHERE `id` IN "+in+" AND `submission_closed` = true", args...); err != nil {
+		return err
+	}
+	if len(closed) == 0 {
+		return nil
+	}
+
+	keys := make([]string, 0, len(closed))
+	ClassCacheMux.Lock()
+	for i := range closed {
+		if _, ok := ClassCacheMap[closed[i].ID]; ok {
+			ClassCacheMap[closed[i].ID] = &closed[i]
+		}
+		keys = append(keys, classesKey(closed[i].CourseID))
+	}
+	ClassCacheMux.Unlock()
+
+	bumpVersion(keys...)
+	return nil
+}
+
+// CloseSubmission POST /api/courses/:courseID/classes/:classID/assignments/close 課題提出の締め切り
+func (h *handlers) CloseSubmission(c echo.Context) error {
+	courseID := c.Param("courseID")
+	classID := c.Param("classID")
+
+	current, ok := h.checkClassStaff(c, courseID, classID)
+	if !ok {
+		return nil
+	}
+	if current.SubmissionClosed {
+		return c.NoContent(http.StatusOK)
+	}
+
+	if err := h.setSubmissionClosed(h.DB, classID, true, current.Deadline); err != nil {
+		c.Logger().Error(err)
+		return c.NoContent(http.StatusInternalServerError)
+	}
+
+	class := *current
+	class.SubmissionClosed = true
+	ClassCacheMux.Lock()
+	ClassCacheMap[classID] = &class
+	ClassCacheMux.Unlock()
+
+	bumpVersion(classesKey(courseID))
+	return c.NoContent(http.StatusOK)
+}
+
+type OpenSubmissionRequest struct {
+	// 新しい提出期限(RFC3339)。省略時は期限を過ぎていれば期限をなくし、そうでなければ元の期限のまま
+	Deadline *string `json:"deadline"`
+}
+
+// OpenSubmission POST /api/courses/:courseID/classes/:classID/assignments/open 課題提出の再開
+func (h *handlers) OpenSubmission(c echo.Context) error {
+	courseID := c.Param("courseID")
+	classID := c.Param("classID")
+
+	var req OpenSubmissionRequest
+	if c.Request().ContentLength != 0 {
+		if err := c.Bind(&req); err != nil {
+			return c.String(http.StatusBadRequest, "Invalid format.")
+		}
+	}
+
+	current, ok := h.checkClassStaff(c, courseID, classID)
+	if !ok {
+		return nil
+	}
+	if _, course := h.getCourse(courseID); course.Status != StatusInProgress {
+		return c.String(http.StatusBadRequest, "This course is not in-progress.")
+	}
+
+	now := time.Now()
+	deadline := current.Deadline
+	if req.Deadline != nil {
+		var err error
+		if deadline, err = parseDeadline(*req.Deadline); err != nil {
+			return c.String(http.StatusBadRequest, "Invalid deadline.")
+		}
+	} else if current.isDeadlinePassed(now) {
+		deadline = sql.NullTime{}
+	}
+	class := *current
+	class.Deadline = deadline
+	if class.isDeadlinePassed(now) {
+		return c.String(http.StatusBadRequest, "The deadline has already passed.")
+	}
+
+	if err := h.setSubmissionClosed(h.DB, classID, false, deadline); err != nil {
+		c.Logger().Error(err)
+		return c.NoContent(http.StatusInternalServerError)
+	}
+
+	class.SubmissionClosed = false
+	ClassCacheMux.Lock()
+	ClassCacheMap[classID] = &class
+	ClassCacheMux.Unlock()
+
+	bumpVersion(classesKey(courseID))
+	return c.NoContent(http.StatusOK)
+}
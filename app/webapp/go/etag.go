@@ -0,0 +1,197 @@
+package main
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/labstack/echo/v4"
+)
+
+// エンティティ毎のバージョンを管理し、それを元に強いETagを発行する
+// 更新系のハンドラはbumpVersionで関係するキーを無効化すること
+
+type entityVersion struct {
+	version      uint64
+	lastModified time.Time
+}
+
+var (
+	versionMap   = make(map[string]*entityVersion)
+	versionMux   = sync.RWMutex{}
+	versionEpoch = time.Now().Truncate(time.Second)
+)
+
+const catalogKey = "catalog"
+
+func courseKey(courseID string) string {
+	return "course/" + courseID
+}
+
+func classesKey(courseID string) string {
+	return "classes/" + courseID
+}
+
+func submissionsKey(courseID string, userID string) string {
+	return "submissions/" + courseID + "/" + userID
+}
+
+func registrationsKey(userID string) string {
+	return "registrations/" + userID
+}
+
+func announcementsKey(userID string) string {
+	return "announcements/" + userID
+}
+
+func announcementKey(announcementID string) string {
+	return "announcement/" + announcementID
+}
+
+// 成績の統計値は他の学生の採点結果にも依存するので全体で一つのキーとする
+const gradesKey = "grades"
+
+func bumpVersion(keys ...string) {
+	now := time.Now().Truncate(time.Second)
+
+	versionMux.Lock()
+	for _, key := range keys {
+		v, ok := versionMap[key]
+		if !ok {
+			v = &entityVersion{}
+			versionMap[key] = v
+		}
+		v.version++
+		v.lastModified = now
+	}
+	versionMux.Unlock()
+}
+
+func resetVersions() {
+	versionMux.Lock()
+	versionMap = make(map[string]*entityVersion)
+	versionEpoch = time.Now().Truncate(time.Second)
+	versionMux.Unlock()
+}
+
+// computeETag キーのバージョンとリクエストURI、ユーザーIDからETagと最終更新日時を計算する
+func computeETag(uri string, userID string, keys []string) (string, time.Time) {
+	hash := sha1.New()
+	hash.Write([]byte(uri))
+	hash.Write([]byte{0})
+	hash.Write([]byte(userID))
+
+	versionMux.RLock()
+	// 再起動やinitializeを跨いで同じETagにならないようにepochも含める
+	hash.Write([]byte(strconv.FormatInt(versionEpoch.UnixNano(), 10)))
+	lastModified := versionEpoch
+	for _, key := range keys {
+		hash.Write([]byte{0})
+		hash.Write([]byte(key))
+		if v, ok := versionMap[key]; ok {
+			hash.Write([]byte("@" + strconv.FormatUint(v.version, 10)))
+			if v.lastModified.After(lastModified) {
+				lastModified = v.lastModified
+			}
+		}
+	}
+	versionMux.RUnlock()
+
+	return "\"" + hex.EncodeToString(hash.Sum(nil)) + "\"", lastModified
+}
+
+func etagMatches(ifNoneMatch string, etag string) bool {
+	for _, candidate := range strings.Split(ifNoneMatch, ",") {
+		if candidate = strings.TrimSpace(candidate); candidate == "*" || candidate == etag {
+			return true
+		}
+	}
+	return false
+}
+
+// ConditionalGET If-None-Match / If-Modified-Since を処理するmiddleware
+// keysにはレスポンスが依存するエンティティのキーを返す関数を渡す
+func (h *handlers) ConditionalGET(keys func(c echo.Context, userID string) []string) echo.MiddlewareFunc {
+	return h.conditionalGET(keys, nil)
+}
+
+// CourseConditionalGET 科目詳細用のConditionalGET
+// ETagの先頭に科目のバージョンを付け、クライアントがそのままIf-Matchに付けて科目を更新できるようにする
+func (h *handlers) CourseConditionalGET(keys func(c echo.Context, userID string) []string) echo.MiddlewareFunc {
+	return h.conditionalGET(keys, func(c echo.Context) string {
+		ok, course := h.getCourse(c.Param("courseID"))
+		if !ok {
+			return ""
+		}
+		return strconv.FormatUint(uint64(course.Version), 10) + "-"
+	})
+}
+
+func (h *handlers) conditionalGET(keys func(c echo.Context, userID string) []string, prefix func(c echo.Context) string) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			userID, _, _ := getSession(c)
+			etag, lastModified := computeETag(c.Request().URL.RequestURI(), userID, keys(c, userID))
+			if prefix != nil {
+				etag = "\"" + prefix(c) + etag[1:]
+			}
+
+			// エラーのレスポンスがキャッシュされないよう、ETagは304と2xxのレスポンスにのみ付ける
+			res := c.Response()
+			res.Before(func() {
+				if res.Status == http.StatusNotModified || res.Status >= 200 && res.Status < 300 {
+					header := res.Header()
+					header.Set("ETag", etag)
+					header.Set("Last-Modified", lastModified.UTC().Format(http.TimeFormat))
+				}
+			})
+
+			req := c.Request()
+			if ifNoneMatch := req.Header.Get("If-None-Match"); ifNoneMatch != "" {
+				if etagMatches(ifNoneMatch, etag) {
+					return c.NoContent(http.StatusNotModified)
+				}
+			} else if ifModifiedSince := req.Header.Get("If-Modified-Since"); ifModifiedSince != "" {
+				// 秒単位でしか比較できないので、同じ秒に更新があった可能性がある場合は304にしない
+				if t, err := http.ParseTime(ifModifiedSince); err == nil && !lastModified.After(t) && lastModified.Before(time.Now().Truncate(time.Second)) {
+					return c.NoContent(http.StatusNotModified)
+				}
+			}
+
+			return next(c)
+		}
+	}
+}
+
+func courseDetailKeys(c echo.Context, _ string) []string {
+	return []string{courseKey(c.Param("courseID"))}
+}
+
+func searchCoursesKeys(echo.Context, string) []string {
+	return []string{catalogKey}
+}
+
+func registeredCoursesKeys(_ echo.Context, userID string) []string {
+	return []string{catalogKey, registrationsKey(userID)}
+}
+
+func gradesKeys(_ echo.Context, userID string) []string {
+	return []string{gradesKey, registrationsKey(userID)}
+}
+
+func classesKeys(c echo.Context, userID string) []string {
+	courseID := c.Param("courseID")
+	return []string{courseKey(courseID), classesKey(courseID), submissionsKey(courseID, userID)}
+}
+
+func announcementListKeys(_ echo.Context, userID string) []string {
+	return []string{catalogKey, registrationsKey(userID), announcementsKey(userID)}
+}
+
+func announcementDetailKeys(c echo.Context, userID string) []string {
+	return []string{catalogKey, registrationsKey(userID), announcementsKey(userID), announcementKey(c.Param("announcementID"))}
+}
@@ -0,0 +1,278 @@
+package main
+
+import (
+	"database/sql"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/labstack/echo/v4"
+)
+
+// 学生個別の提出期限の延長
+// 延長された学生には延長後の期限が提出期限になり、猶予期間と遅延提出の減点もそれを基準にする
+// 講義は全員の期限(延長を含む)を過ぎるまでスケジューラに締め切られない
+
+type DeadlineExtension struct {
+	UserCode      string    `json:"user_code" db:"user_code"`
+	UserName      string    `json:"user_name" db:"user_name"`
+	Deadline      time.Time `json:"deadline" db:"deadline"`
+	Reason        string    `json:"reason" db:"reason"`
+	GrantedByCode string    `json:"granted_by" db:"granted_by_code"`
+	CreatedAt     time.Time `json:"created_at" db:"created_at"`
+}
+
+func extensionKey(classID string, userID string) string {
+	return classID + "/" + userID
+}
+
+// withExtension 学生の延長後の期限を提出期限とした講義を返す
+func (c *Class) withExtension(extensions map[string]time.Time, userID string) *Class {
+	deadline, ok := extensions[extensionKey(c.ID, userID)]
+	if !ok {
+		return c
+	}
+	extended := *c
+	extended.Deadline = sql.NullTime{Time: deadline, Valid: true}
+	return &extended
+}
+
+// getDeadlineExtensions 延長後の期限を extensionKey(講義ID, 学生ID) をキーにして返す
+// courseIDが空文字列の場合は全科目、userIDが空文字列の場合は全学生分
+func getDeadlineExtensions(db sqlx.Queryer, courseID string, userID string) (map[string]time.Time, error) {
+	type extensionS struct {
+		ClassID  string    `db:"class_id"`
+		UserID   string    `db:"user_id"`
+		Deadline time.Time `db:"deadline"`
+	}
+	var extensions []extensionS
+	var conditions []string
+	var args []interface{}
+	if courseID != "" {
+		conditions = append(conditions, "`classes`.`course_id` = ?")
+		args = append(args, courseID)
+	}
+	if userID != "" {
+		conditions = append(conditions, "`deadline_extensions`.`user_id` = ?")
+		args = append(args, userID)
+	}
+	query := "SELECT `deadline_extensions`.`class_id`, `deadline_extensions`.`user_id`, `deadline_extensions`.`deadline`" +
+		" FROM `deadline_extensions`" +
+		" JOIN `classes` ON `classes`.`id` = `deadline_extensions`.`class_id`"
+	if len(conditions) > 0 {
+		query += " WHERE " + strings.Join(conditions, " AND ")
+	}
+	if err := sqlx.Select(db, &extensions, query, args...); err != nil {
+		return nil, err
+	}
+	res := make(map[string]time.Time, len(extensions))
+	for _, e := range extensions {
+		res[extensionKey(e.ClassID, e.UserID)] = e.Deadline
+	}
+	return res, nil
+}
+
+// getEffectiveClass 学生にとっての講義(延長されていれば延長後の期限)を返す
+func (h *handlers) getEffectiveClass(class *Class, userID string) (*Class, error) {
+	if !class.Deadline.Valid {
+		return class, nil
+	}
+	var deadline time.Time
+	err := h.Balance().Get(&deadline, "SELECT `deadline` FROM `deadline_extensions` WHERE `class_id` = ? AND `user_id` = ?", class.ID, userID)
+	if err == sql.ErrNoRows {
+		return class, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return class.withExtension(map[string]time.Time{extensionKey(class.ID, userID): deadline}, userID), nil
+}
+
+// GetDeadlineExtensions GET /api/courses/:courseID/classes/:classID/extensions 講義の提出期限の延長一覧
+func (h *handlers) GetDeadlineExtensions(c echo.Context) error {
+	courseID := c.Param("courseID")
+	classID := c.Param("classID")
+
+	_, ok := h.checkClassStaff(c, courseID, classID)
+	if !ok {
+		return nil
+	}
+
+	// 結果が0件の時は空配列を返却
+	extensions := make([]DeadlineExtension, 0)
+	query := "SELECT `students`.`code` AS `user_code`, `students`.`name` AS `user_name`, `deadline_extensions`.`deadline`," +
+		" `deadline_extensions`.`reason`, `teachers`.`code` AS `granted_by_code`, `deadline_extensions`.`created_at`" +
+		" FROM `deadline_extensions`" +
+		" JOIN `users` AS `students` ON `students`.`id` = `deadline_extensions`.`user_id`" +
+		" JOIN `users` AS `teachers` ON `teachers`.`id` = `deadline_extensions`.`granted_by`" +
+		" WHERE `deadline_extensions`.`class_id` = ?" +
+		" ORDER BY `students`.`code`"
+	if err := h.Balance().Select(&extensions, query, classID); err != nil {
+		c.Logger().Error(err)
+		return c.NoContent(http.StatusInternalServerError)
+	}
+
+	return c.JSON(http.StatusOK, extensions)
+}
+
+type GrantDeadlineExtensionRequest struct {
+	UserCode string `json:"user_code"`
+	// 延長後の提出期限(RFC3339)
+	Deadline string `json:"deadline"`
+	Reason   string `json:"reason"`
+}
+
+// GrantDeadlineExtension POST /api/courses/:courseID/classes/:classID/extensions 学生の提出期限の延長(既に延長されていれば上書き)
+func (h *handlers) GrantDeadlineExtension(c echo.Context) error {
+	userID, _, _, err := getUserInfo(c)
+	if err != nil {
+		c.Logger().Error(err)
+		return c.NoContent(http.StatusInternalServerError)
+	}
+
+	courseID := c.Param("courseID")
+	classID := c.Param("classID")
+
+	var req GrantDeadlineExtensionRequest
+	if err := c.Bind(&req); err != nil {
+		return c.String(http.StatusBadRequest, "Invalid format.")
+	}
+
+	class, ok := h.checkClassStaff(c, courseID, classID)
+	if !ok {
+		return nil
+	}
+	if !class.Deadline.Valid {
+		return c.String(http.StatusBadRequest, "This class has no deadline.")
+	}
+	deadline, err := parseDeadline(req.Deadline)
+	if err != nil || !deadline.Valid {
+		return c.String(http.StatusBadRequest, "Invalid deadline.")
+	}
+	if !deadline.Time.After(class.Deadline.Time) {
+		return c.String(http.StatusBadRequest, "The extended deadline must be after the deadline of the class.")
+	}
+
+	var student User
+	if err := h.Balance().Get(&student, "SELECT * FROM `users` WHERE `code` = ?", req.UserCode); err != nil {
+		if err == sql.ErrNoRows {
+			return c.String(http.StatusNotFound, "No such student.")
+		}
+		c.Logger().Error(err)
+		return c.NoContent(http.StatusInternalServerError)
+	}
+	registered, err := h.isUserRegistered(student.ID, courseID)
+	if err != nil {
+		c.Logger().Error(err)
+		return c.NoContent(http.StatusInternalServerError)
+	}
+	if !registered {
+		return c.String(http.StatusBadRequest, "The student has not taken this course.")
+	}
+
+	tx, err := h.DB.Beginx()
+	if err != nil {
+		c.Logger().Error(err)
+		return c.NoContent(http.StatusInternalServerError)
+	}
+	defer tx.Rollback()
+
+	createdAt := time.Now().Truncate(time.Microsecond)
+	query := "INSERT INTO `deadline_extensions` (`class_id`, `user_id`, `deadline`, `reason`, `granted_by`, `created_at`) VALUES (?, ?, ?, ?, ?, ?)" +
+		" ON DUPLICATE KEY UPDATE `deadline` = VALUES(`deadline`), `reason` = VALUES(`reason`), `granted_by` = VALUES(`granted_by`), `created_at` = VALUES(`created_at`)"
+	h.SubDB.Exec(query, classID, student.ID, deadline, req.Reason, userID, createdAt)
+	if _, err := tx.Exec(query, classID, student.ID, deadline, req.Reason, userID, createdAt); err != nil {
+		c.Logger().Error(err)
+		return c.NoContent(http.StatusInternalServerError)
+	}
+
+	// 期限によって締め切られた講義は、延長された学生が提出できるよう受付を再開する
+	// 延長されていない学生はSubmitAssignmentで期限により拒否される
+	extended := class.withExtension(map[string]time.Time{extensionKey(classID, student.ID): deadline.Time}, student.ID)
+	reopen := class.SubmissionClosed && class.isDeadlinePassed(createdAt) && !extended.isDeadlinePassed(createdAt)
+	if reopen {
+		if err := h.setSubmissionClosed(tx, classID, false, class.Deadline); err != nil {
+			c.Logger().Error(err)
+			return c.NoContent(http.StatusInternalServerError)
+		}
+	}
+
+	// 遅延提出の減点が変わるので合計点を計算し直す
+	if err := h.updateTotalScores(tx, courseID); err != nil {
+		c.Logger().Error(err)
+		return c.NoContent(http.StatusInternalServerError)
+	}
+
+	if err := tx.Commit(); err != nil {
+		c.Logger().Error(err)
+		return c.NoContent(http.StatusInternalServerError)
+	}
+
+	if reopen {
+		reopened := *class
+		reopened.SubmissionClosed = false
+		ClassCacheMux.Lock()
+		ClassCacheMap[classID] = &reopened
+		ClassCacheMux.Unlock()
+	}
+
+	bumpVersion(classesKey(courseID), gradesKey)
+	return c.NoContent(http.StatusOK)
+}
+
+// RevokeDeadlineExtension DELETE /api/courses/:courseID/classes/:classID/extensions/:userCode 学生の提出期限の延長の取り消し
+// 講義の期限を過ぎていれば、スケジューラが講義を締め切る
+func (h *handlers) RevokeDeadlineExtension(c echo.Context) error {
+	courseID := c.Param("courseID")
+	classID := c.Param("classID")
+	userCode := c.Param("userCode")
+
+	_, ok := h.checkClassStaff(c, courseID, classID)
+	if !ok {
+		return nil
+	}
+
+	var studentID string
+	if err := h.Balance().Get(&studentID, "SELECT `id` FROM `users` WHERE `code` = ?", userCode); err != nil {
+		if err == sql.ErrNoRows {
+			return c.String(http.StatusNotFound, "No such student.")
+		}
+		c.Logger().Error(err)
+		return c.NoContent(http.StatusInternalServerError)
+	}
+
+	tx, err := h.DB.Beginx()
+	if err != nil {
+		c.Logger().Error(err)
+		return c.NoContent(http.StatusInternalServerError)
+	}
+	defer tx.Rollback()
+
+	query := "DELETE FROM `deadline_extensions` WHERE `class_id` = ? AND `user_id` = ?"
+	h.SubDB.Exec(query, classID, studentID)
+	result, err := tx.Exec(query, classID, studentID)
+	if err != nil {
+		c.Logger().Error(err)
+		return c.NoContent(http.StatusInternalServerError)
+	}
+	if n, err := result.RowsAffected(); err != nil {
+		c.Logger().Error(err)
+		return c.NoContent(http.StatusInternalServerError)
+	} else if n == 0 {
+		return c.String(http.StatusNotFound, "No such extension.")
+	}
+
+	if err := h.updateTotalScores(tx, courseID); err != nil {
+		c.Logger().Error(err)
+		return c.NoContent(http.StatusInternalServerError)
+	}
+
+	if err := tx.Commit(); err != nil {
+		c.Logger().Error(err)
+		return c.NoContent(http.StatusInternalServerError)
+	}
+
+	bumpVersion(classesKey(courseID), gradesKey)
+	return c.NoContent(http.StatusNoContent)
+}
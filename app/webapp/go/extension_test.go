@@ -0,0 +1,55 @@
+package main
+
+import (
+	"database/sql"
+	"testing"
+	"time"
+)
+
+func TestWithExtension(t *testing.T) {
+	deadline := time.Date(2021, 9, 18, 10, 0, 0, 0, time.UTC)
+	extended := deadline.Add(48 * time.Hour)
+	class := &Class{
+		ID:          "class1",
+		Deadline:    sql.NullTime{Time: deadline, Valid: true},
+		GracePeriod: 60,
+		LatePolicy:  LatePolicyZero,
+	}
+	extensions := map[string]time.Time{
+		extensionKey("class1", "student1"): extended,
+		extensionKey("class2", "student2"): extended,
+	}
+
+	t.Run("without extension", func(t *testing.T) {
+		if got := class.withExtension(extensions, "student2"); got != class {
+			t.Errorf("withExtension() = %p, want the same class %p", got, class)
+		}
+	})
+
+	t.Run("with extension", func(t *testing.T) {
+		got := class.withExtension(extensions, "student1")
+		if got == class {
+			t.Fatal("withExtension() returned the original class")
+		}
+		if !got.Deadline.Valid || !got.Deadline.Time.Equal(extended) {
+			t.Errorf("Deadline = %v, want %v", got.Deadline, extended)
+		}
+		if got.GracePeriod != class.GracePeriod || got.LatePolicy != class.LatePolicy {
+			t.Errorf("late policy changed: %+v", got)
+		}
+		if !class.Deadline.Time.Equal(deadline) {
+			t.Errorf("original Deadline = %v, want %v", class.Deadline.Time, deadline)
+		}
+		if late, _ := got.latePenalty(deadline.Add(time.Hour)); late {
+			t.Error("submission before the extended deadline is late")
+		}
+	})
+
+	t.Run("class without deadline", func(t *testing.T) {
+		noDeadline := &Class{ID: "class1"}
+		got := noDeadline.withExtension(extensions, "student1")
+		if !got.Deadline.Valid || !got.Deadline.Time.Equal(extended) {
+			t.Errorf("Deadline = %v, want %v", got.Deadline, extended)
+		}
+	})
+}
@@ -1,6 +1,9 @@
 package main
 
 import (
+	"time"
+
+	"github.com/jmoiron/sqlx"
 	"golang.org/x/sync/singleflight"
 )
 
@@ -35,3 +38,64 @@ func (h *handlers) getGPAStats() ([]float64, error) {
 	}
 	return r.([]float64), nil
 }
+
+// updateTotalScores 科目を履修している学生の合計点を計算し直す
+// 遅延提出の減点は講義の規則と提出日時(延長された学生は延長後の期限)から求めて適用する
+func (h *handlers) updateTotalScores(db sqlx.Ext, courseID string) error {
+	var classes []Class
+	if err := sqlx.Select(db, &classes, "SELECT * FROM `classes` WHERE `course_id` = ?", courseID); err != nil {
+		return err
+	}
+	classMap := make(map[string]*Class, len(classes))
+	for i := range classes {
+		classMap[classes[i].ID] = &classes[i]
+	}
+
+	type scoreS struct {
+		UserID      string    `db:"user_id"`
+		ClassID     string    `db:"class_id"`
+		Score       int       `db:"score"`
+		SubmittedAt time.Time `db:"submitted_at"`
+	}
+	var scores []scoreS
+	query := "SELECT `submissions`.`user_id`, `submissions`.`class_id`, `submissions`.`score`, `submissions`.`submitted_at`" +
+		" FROM `registrations`" +
+		" JOIN `classes` ON `classes`.`course_id` = `registrations`.`course_id`" +
+		" JOIN `submissions` ON `submissions`.`user_id` = `registrations`.`user_id` AND `submissions`.`class_id` = `classes`.`id`" +
+		" WHERE `registrations`.`course_id` = ? AND `submissions`.`score` IS NOT NULL"
+	if err := sqlx.Select(db, &scores, query, courseID); err != nil {
+		return err
+	}
+	extensions, err := getDeadlineExtensions(db, courseID, "")
+	if err != nil {
+		return err
+	}
+	var userIDs []string
+	if err := sqlx.Select(db, &userIDs, "SELECT `user_id` FROM `registrations` WHERE `course_id` = ?", courseID); err != nil {
+		return err
+	}
+
+	type totalScoreS struct {
+		UserID     string
+		TotalScore int
+	}
+	totalMap := make(map[string]int, len(userIDs))
+	for _, s := range scores {
+		_, penalty := classMap[s.ClassID].withExtension(extensions, s.UserID).latePenalty(s.SubmittedAt)
+		totalMap[s.UserID] += applyPenalty(s.Score, penalty)
+	}
+	totals := make([]totalScoreS, 0, len(userIDs))
+	for _, userID := range userIDs {
+		totals = append(totals, totalScoreS{UserID: userID, TotalScore: totalMap[userID]})
+	}
+
+	for _, total := range totals {
+		if _, err := db.Exec("INSERT INTO `user_course_total_scores` (`total_score`, `course_id`, `user_id`) VALUES (?, ?, ?) ON DUPLICATE KEY UPDATE total_score = ?", total.TotalScore, courseID, total.UserID, total.TotalScore); err != nil {
+			return err
+		}
+		if _, err := h.SubDB.Exec("INSERT INTO `user_course_total_scores` (`total_score`, `course_id`, `user_id`) VALUES (?, ?, ?) ON DUPLICATE KEY UPDATE total_score = ?", total.TotalScore, courseID, total.UserID, total.TotalScore); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,174 @@
+package main
+
+import (
+	"database/sql"
+	"net/http"
+	"time"
+
+	"github.com/labstack/echo/v4"
+)
+
+// 遅延提出
+// 提出期限の後も猶予期間(grace_period)の間は提出を受け付け、講義毎の規則で減点する
+// 減点は提出日時と期限から合計点の計算時に求めるので、規則を変更すると既存の提出物にも反映される
+
+type LatePolicy string
+
+const (
+	// 猶予期間内であれば減点しない
+	LatePolicyNone LatePolicy = "none"
+	// 期限から24時間毎にlate_penalty_rate%減点する。減点はlate_penalty_cap%まで
+	LatePolicyPerDay LatePolicy = "per-day"
+	// 遅延提出は0点
+	LatePolicyZero LatePolicy = "zero"
+)
+
+func isValidLatePolicy(policy LatePolicy) bool {
+	return policy == LatePolicyNone || policy == LatePolicyPerDay || policy == LatePolicyZero
+}
+
+// LatePolicyRequest 遅延提出の設定。講義の追加・更新のリクエストに埋め込み、省略した項目は変更しない
+type LatePolicyRequest struct {
+	// 提出期限の後に提出を受け付ける秒数
+	GracePeriod     *uint32     `json:"grace_period"`
+	LatePolicy      *LatePolicy `json:"late_policy"`
+	LatePenaltyRate *uint8      `json:"late_penalty_rate"`
+	LatePenaltyCap  *uint8      `json:"late_penalty_cap"`
+}
+
+// apply リクエストの設定を講義に反映する
+func (r *LatePolicyRequest) apply(class *Class) bool {
+	if r.GracePeriod != nil {
+		class.GracePeriod = *r.GracePeriod
+	}
+	if r.LatePolicy != nil {
+		if !isValidLatePolicy(*r.LatePolicy) {
+			return false
+		}
+		class.LatePolicy = *r.LatePolicy
+	}
+	if r.LatePenaltyRate != nil {
+		if *r.LatePenaltyRate > 100 {
+			return false
+		}
+		class.LatePenaltyRate = *r.LatePenaltyRate
+	}
+	if r.LatePenaltyCap != nil {
+		if *r.LatePenaltyCap > 100 {
+			return false
+		}
+		class.LatePenaltyCap = *r.LatePenaltyCap
+	}
+	return true
+}
+
+// closesAt 提出を締め切る日時(提出期限 + 猶予期間)
+func (c *Class) closesAt() time.Time {
+	return c.Deadline.Time.Add(time.Duration(c.GracePeriod) * time.Second)
+}
+
+func closesAtPtr(c *Class) *time.Time {
+	if !c.Deadline.Valid || c.GracePeriod == 0 {
+		return nil
+	}
+	v := c.closesAt()
+	return &v
+}
+
+// latePenalty 提出日時に対する減点(%)。期限後の提出であればlateがtrueになる
+func (c *Class) latePenalty(submittedAt time.Time) (late bool, penalty int) {
+	if !c.Deadline.Valid || !submittedAt.After(c.Deadline.Time) {
+		return false, 0
+	}
+
+	switch c.LatePolicy {
+	case LatePolicyZero:
+		return true, 100
+	case LatePolicyPerDay:
+		// 遅れた日数は切り上げる
+		days := int((submittedAt.Sub(c.Deadline.Time) + 24*time.Hour - 1) / (24 * time.Hour))
+		penalty = days * int(c.LatePenaltyRate)
+		if penalty > int(c.LatePenaltyCap) {
+			penalty = int(c.LatePenaltyCap)
+		}
+		return true, penalty
+	default:
+		return true, 0
+	}
+}
+
+func applyPenalty(score int, penalty int) int {
+	return score * (100 - penalty) / 100
+}
+
+type GetSubmissionResponse struct {
+	UserCode    string    `json:"user_code"`
+	UserName    string    `json:"user_name"`
+	FileName    string    `json:"file_name"`
+	SubmittedAt time.Time `json:"submitted_at"`
+	Late        bool      `json:"late"`
+	Penalty     int       `json:"penalty"`
+	// 減点前の点数。未採点の場合はnull
+	RawScore *int `json:"raw_score"`
+	// 減点後の点数。未採点の場合はnull
+	Score *int `json:"score"`
+}
+
+// GetSubmissions GET /api/courses/:courseID/classes/:classID/assignments 講義の提出物と採点結果の一覧(遅延提出の有無を含む)
+func (h *handlers) GetSubmissions(c echo.Context) error {
+	courseID := c.Param("courseID")
+	classID := c.Param("classID")
+
+	class, ok := h.checkClassStaff(c, courseID, classID)
+	if !ok {
+		return nil
+	}
+
+	extensions, err := getDeadlineExtensions(h.Balance(), courseID, "")
+	if err != nil {
+		c.Logger().Error(err)
+		return c.NoContent(http.StatusInternalServerError)
+	}
+
+	type submissionS struct {
+		UserID      string        `db:"user_id"`
+		UserCode    string        `db:"user_code"`
+		UserName    string        `db:"user_name"`
+		FileName    string        `db:"file_name"`
+		SubmittedAt time.Time     `db:"submitted_at"`
+		Score       sql.NullInt64 `db:"score"`
+	}
+	var submissions []submissionS
+	query := "SELECT `submissions`.`user_id`, `users`.`code` AS `user_code`, `users`.`name` AS `user_name`, `submissions`.`file_name`, `submissions`.`submitted_at`, `submissions`.`score`" +
+		" FROM `submissions`" +
+		" JOIN `users` ON `users`.`id` = `submissions`.`user_id`" +
+		" WHERE `submissions`.`class_id` = ?" +
+		" ORDER BY `users`.`code`"
+	if err := h.Balance().Select(&submissions, query, classID); err != nil {
+		c.Logger().Error(err)
+		return c.NoContent(http.StatusInternalServerError)
+	}
+
+	// 結果が0件の時は空配列を返却
+	res := make([]GetSubmissionResponse, 0, len(submissions))
+	for _, s := range submissions {
+		late, penalty := class.withExtension(extensions, s.UserID).latePenalty(s.SubmittedAt)
+		r := GetSubmissionResponse{
+			UserCode:    s.UserCode,
+			UserName:    s.UserName,
+			FileName:    s.FileName,
+			SubmittedAt: s.SubmittedAt,
+			Late:        late,
+			Penalty:     penalty,
+		}
+		if s.Score.Valid {
+			raw := int(s.Score.Int64)
+			score := applyPenalty(raw, penalty)
+			r.RawScore = &raw
+			r.Score = &score
+		}
+		res = append(res, r)
+	}
+
+	return c.JSON(http.StatusOK, res)
+}
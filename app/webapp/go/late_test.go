@@ -0,0 +1,98 @@
+package main
+
+import (
+	"database/sql"
+	"testing"
+	"time"
+)
+
+func TestLatePenalty(t *testing.T) {
+	deadline := time.Date(2021, 9, 18, 10, 0, 0, 0, time.UTC)
+	perDay := Class{
+		Deadline:        sql.NullTime{Time: deadline, Valid: true},
+		LatePolicy:      LatePolicyPerDay,
+		LatePenaltyRate: 10,
+		LatePenaltyCap:  30,
+	}
+	zero := perDay
+	zero.LatePolicy = LatePolicyZero
+	none := perDay
+	none.LatePolicy = LatePolicyNone
+	noDeadline := perDay
+	noDeadline.Deadline = sql.NullTime{}
+
+	tests := []struct {
+		name        string
+		class       Class
+		submittedAt time.Time
+		late        bool
+		penalty     int
+	}{
+		{"before deadline", perDay, deadline.Add(-time.Second), false, 0},
+		{"at deadline", perDay, deadline, false, 0},
+		{"just after deadline counts as one day", perDay, deadline.Add(time.Nanosecond), true, 10},
+		{"exactly one day", perDay, deadline.Add(24 * time.Hour), true, 10},
+		{"one day and a second rounds up", perDay, deadline.Add(24*time.Hour + time.Second), true, 20},
+		{"penalty is capped", perDay, deadline.Add(10 * 24 * time.Hour), true, 30},
+		{"zero policy", zero, deadline.Add(time.Minute), true, 100},
+		{"zero policy before deadline", zero, deadline.Add(-time.Minute), false, 0},
+		{"none policy", none, deadline.Add(48 * time.Hour), true, 0},
+		{"no deadline", noDeadline, deadline.Add(48 * time.Hour), false, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			late, penalty := tt.class.latePenalty(tt.submittedAt)
+			if late != tt.late || penalty != tt.penalty {
+				t.Errorf("latePenalty() = (%v, %d), want (%v, %d)", late, penalty, tt.late, tt.penalty)
+			}
+		})
+	}
+}
+
+func TestApplyPenalty(t *testing.T) {
+	tests := []struct {
+		score   int
+		penalty int
+		want    int
+	}{
+		{100, 0, 100},
+		{100, 10, 90},
+		{100, 100, 0},
+		{55, 30, 38}, // 切り捨て
+		{0, 50, 0},
+	}
+	for _, tt := range tests {
+		if got := applyPenalty(tt.score, tt.penalty); got != tt.want {
+			t.Errorf("applyPenalty(%d, %d) = %d, want %d", tt.score, tt.penalty, got, tt.want)
+		}
+	}
+}
+
+func TestClosesAt(t *testing.T) {
+	deadline := time.Date(2021, 9, 18, 10, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name     string
+		class    Class
+		closesAt time.Time
+		ptr      *time.Time
+	}{
+		{"no grace period", Class{Deadline: sql.NullTime{Time: deadline, Valid: true}}, deadline, nil},
+		{"grace period", Class{Deadline: sql.NullTime{Time: deadline, Valid: true}, GracePeriod: 90}, deadline.Add(90 * time.Second), timePtr(deadline.Add(90 * time.Second))},
+		{"no deadline", Class{GracePeriod: 90}, time.Time{}, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.class.closesAt(); tt.class.Deadline.Valid && !got.Equal(tt.closesAt) {
+				t.Errorf("closesAt() = %v, want %v", got, tt.closesAt)
+			}
+			got := closesAtPtr(&tt.class)
+			if (got == nil) != (tt.ptr == nil) || got != nil && !got.Equal(*tt.ptr) {
+				t.Errorf("closesAtPtr() = %v, want %v", got, tt.ptr)
+			}
+		})
+	}
+}
+
+func timePtr(t time.Time) *time.Time {
+	return &t
+}
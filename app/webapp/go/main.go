@@ -9,7 +9,6 @@ import (
 	"io"
 	"net"
 	"net/http"
-	"net/url"
 	"os"
 	"os/exec"
 	"os/signal"
@@ -19,6 +18,7 @@ import (
 	"sync"
 	"sync/atomic"
 	"time"
+	"unicode/utf8"
 
 	"github.com/goccy/go-json"
 
@@ -120,6 +120,11 @@ func main() {
 		panic(err)
 	}
 
+	// 科目の入出力のサブコマンドが指定された場合はサーバーを起動しない
+	if len(os.Args) > 1 && isCourseCommand(os.Args[1]) {
+		os.Exit(runCourseCommand(os.Args[1:]))
+	}
+
 	e := echo.New()
 	// e.Debug = GetEnv("DEBUG", "") == "true"
 	e.HideBanner = true
@@ -141,37 +146,84 @@ func main() {
 		DB:    db,
 		SubDB: subdb,
 	}
+	go h.runDeadlineScheduler(e.Logger)
 
 	e.POST("/initialize", h.Initialize)
 
 	e.POST("/login", h.Login)
 	e.POST("/logout", h.Logout)
+	// カレンダーアプリから購読するためセッションを必要としない
+	e.GET("/calendar/:token", h.GetCalendarFeedICS)
 	API := e.Group("/api", h.IsLoggedIn)
 	{
 		usersAPI := API.Group("/users")
 		{
 			usersAPI.GET("/me", h.GetMe)
-			usersAPI.GET("/me/courses", h.GetRegisteredCourses)
+			usersAPI.GET("/me/courses", h.GetRegisteredCourses, h.ConditionalGET(registeredCoursesKeys))
 			usersAPI.PUT("/me/courses", h.RegisterCourses)
-			usersAPI.GET("/me/grades", h.GetGrades)
+			usersAPI.POST("/me/courses/validate", h.ValidateRegistration)
+			usersAPI.DELETE("/me/courses/:courseID", h.DropCourse)
+			usersAPI.GET("/me/grades", h.GetGrades, h.ConditionalGET(gradesKeys))
+			usersAPI.GET("/me/waitlists", h.GetMyWaitlists)
+			usersAPI.GET("/me/timetable.ics", h.GetTimetableICS)
+			usersAPI.GET("/me/timetable/feed", h.GetCalendarFeed)
+			usersAPI.POST("/me/timetable/feed", h.ResetCalendarFeed)
 		}
 		coursesAPI := API.Group("/courses")
 		{
-			coursesAPI.GET("", h.SearchCourses)
+			coursesAPI.GET("", h.SearchCourses, h.ConditionalGET(searchCoursesKeys))
 			coursesAPI.POST("", h.AddCourse, h.IsAdmin)
-			coursesAPI.GET("/:courseID", h.GetCourseDetail)
+			coursesAPI.POST("/import", h.ImportCourses, h.IsAdmin)
+			coursesAPI.GET("/export", h.ExportCourses, h.IsAdmin)
+			coursesAPI.GET("/:courseID", h.GetCourseDetail, h.CourseConditionalGET(courseDetailKeys))
+			coursesAPI.PATCH("/:courseID", h.UpdateCourse, h.IsAdmin)
+			coursesAPI.DELETE("/:courseID", h.DeleteCourse, h.IsAdmin)
+			coursesAPI.POST("/:courseID/archive", h.ArchiveCourse, h.IsAdmin)
+			coursesAPI.DELETE("/:courseID/archive", h.UnarchiveCourse, h.IsAdmin)
+			coursesAPI.POST("/:courseID/clone", h.CloneCourse, h.IsAdmin)
+			coursesAPI.GET("/:courseID/syllabus/revisions", h.GetSyllabusRevisions)
+			coursesAPI.GET("/:courseID/syllabus/diff", h.GetSyllabusDiff)
 			coursesAPI.PUT("/:courseID/status", h.SetCourseStatus, h.IsAdmin)
-			coursesAPI.GET("/:courseID/classes", h.GetClasses)
+			coursesAPI.PUT("/:courseID/owner", h.ReassignCourseOwner, h.IsAdmin)
+			coursesAPI.GET("/:courseID/staff", h.GetCourseStaff, h.ConditionalGET(courseDetailKeys))
+			coursesAPI.POST("/:courseID/staff", h.AddCourseStaff, h.IsAdmin)
+			coursesAPI.DELETE("/:courseID/staff/:userID", h.RemoveCourseStaff, h.IsAdmin)
+			coursesAPI.POST("/:courseID/waitlist", h.JoinWaitlist)
+			coursesAPI.DELETE("/:courseID/waitlist", h.LeaveWaitlist)
+			coursesAPI.GET("/:courseID/classes", h.GetClasses, h.ConditionalGET(classesKeys))
 			coursesAPI.POST("/:courseID/classes", h.AddClass, h.IsAdmin)
+			coursesAPI.PUT("/:courseID/classes/order", h.ReorderClasses, h.IsAdmin)
+			coursesAPI.PATCH("/:courseID/classes/:classID", h.UpdateClass, h.IsAdmin)
+			coursesAPI.DELETE("/:courseID/classes/:classID", h.DeleteClass, h.IsAdmin)
+			coursesAPI.GET("/:courseID/classes/:classID/assignments", h.GetSubmissions, h.IsAdmin)
 			coursesAPI.POST("/:courseID/classes/:classID/assignments", h.SubmitAssignment)
 			coursesAPI.PUT("/:courseID/classes/:classID/assignments/scores", h.RegisterScores, h.IsAdmin)
 			coursesAPI.GET("/:courseID/classes/:classID/assignments/export", h.DownloadSubmittedAssignments, h.IsAdmin)
+			coursesAPI.POST("/:courseID/classes/:classID/assignments/close", h.CloseSubmission, h.IsAdmin)
+			coursesAPI.POST("/:courseID/classes/:classID/assignments/open", h.OpenSubmission, h.IsAdmin)
+			coursesAPI.GET("/:courseID/classes/:classID/extensions", h.GetDeadlineExtensions, h.IsAdmin)
+			coursesAPI.POST("/:courseID/classes/:classID/extensions", h.GrantDeadlineExtension, h.IsAdmin)
+			coursesAPI.DELETE("/:courseID/classes/:classID/extensions/:userCode", h.RevokeDeadlineExtension, h.IsAdmin)
+			coursesAPI.GET("/:courseID/attendance", h.GetAttendanceReport, h.IsAdmin)
+			coursesAPI.GET("/:courseID/classes/:classID/materials", h.GetClassMaterials)
+			coursesAPI.POST("/:courseID/classes/:classID/materials", h.UploadClassMaterial, h.IsAdmin)
+			coursesAPI.GET("/:courseID/classes/:classID/materials/:materialID", h.DownloadClassMaterial)
+			coursesAPI.DELETE("/:courseID/classes/:classID/materials/:materialID", h.DeleteClassMaterial, h.IsAdmin)
+			coursesAPI.GET("/:courseID/classes/:classID/attendance", h.GetClassAttendance, h.IsAdmin)
+			coursesAPI.POST("/:courseID/classes/:classID/attendance", h.SubmitAttendance)
+			coursesAPI.PUT("/:courseID/classes/:classID/attendance", h.UpdateClassAttendance, h.IsAdmin)
+			coursesAPI.POST("/:courseID/classes/:classID/attendance/sessions", h.OpenAttendanceSession, h.IsAdmin)
+		}
+		termsAPI := API.Group("/terms")
+		{
+			termsAPI.GET("", h.GetTerms)
+			termsAPI.POST("", h.AddTerm, h.IsAdmin)
 		}
 		announcementsAPI := API.Group("/announcements")
 		{
-			announcementsAPI.GET("", h.GetAnnouncementList)
+			announcementsAPI.GET("", h.GetAnnouncementList, h.ConditionalGET(announcementListKeys))
 			announcementsAPI.POST("", h.AddAnnouncement, h.IsAdmin)
-			announcementsAPI.GET("/:announcementID", h.GetAnnouncementDetail)
+			announcementsAPI.GET("/:announcementID", h.GetAnnouncementDetail, h.ConditionalGET(announcementDetailKeys))
 		}
 	}
 
@@ -235,6 +287,7 @@ func (h *handlers) Initialize(c echo.Context) error {
 		"1_schema.sql",
 		"2_init.sql",
 		"3_sample.sql",
+		"4_migrate.sql",
 	}
 	for _, file := range files {
 		data, err := os.ReadFile(SQLDirectory + file)
@@ -302,10 +355,28 @@ func (h *handlers) Initialize(c echo.Context) error {
 	ClassCacheMap = make(map[string]*Class)
 	ClassCacheMux.Unlock()
 
+	TermCacheMux.Lock()
+	TermCacheMap = make(map[string]*Term)
+	TermCacheMux.Unlock()
+
+	PrerequisiteCacheMux.Lock()
+	PrerequisiteCacheMap = make(map[string][]Prerequisite)
+	PrerequisiteCacheMux.Unlock()
+
+	CourseSlotCacheMux.Lock()
+	CourseSlotCacheMap = make(map[string][]CourseSlot)
+	CourseSlotCacheMux.Unlock()
+
+	CourseStaffCacheMux.Lock()
+	CourseStaffCacheMap = make(map[string][]CourseStaff)
+	CourseStaffCacheMux.Unlock()
+
 	ClassSubmissionMux.Lock()
 	ClassSubmissionCache = make(map[string]struct{})
 	ClassSubmissionMux.Unlock()
 
+	resetVersions()
+
 	return c.JSON(http.StatusOK, res)
 }
 
@@ -372,6 +443,8 @@ const (
 
 var daysOfWeek = []DayOfWeek{Monday, Tuesday, Wednesday, Thursday, Friday}
 
+const periodCount = 6
+
 type CourseStatus string
 
 const (
@@ -381,17 +454,21 @@ const (
 )
 
 type Course struct {
-	ID          string       `db:"id"`
-	Code        string       `db:"code"`
-	Type        CourseType   `db:"type"`
-	Name        string       `db:"name"`
-	Description string       `db:"description"`
-	Credit      uint8        `db:"credit"`
-	Period      uint8        `db:"period"`
-	DayOfWeek   DayOfWeek    `db:"day_of_week"`
-	TeacherID   string       `db:"teacher_id"`
-	Keywords    string       `db:"keywords"`
-	Status      CourseStatus `db:"status"`
+	ID          string         `db:"id"`
+	Code        string         `db:"code"`
+	Type        CourseType     `db:"type"`
+	Name        string         `db:"name"`
+	Description string         `db:"description"`
+	Credit      uint8          `db:"credit"`
+	Period      uint8          `db:"period"`
+	DayOfWeek   DayOfWeek      `db:"day_of_week"`
+	TeacherID   string         `db:"teacher_id"`
+	Keywords    string         `db:"keywords"`
+	Status      CourseStatus   `db:"status"`
+	Version     uint32         `db:"version"`
+	TermID      sql.NullString `db:"term_id"`
+	Capacity    sql.NullInt32  `db:"capacity"`
+	ArchivedAt  sql.NullTime   `db:"archived_at"`
 }
 
 // ---------- Public API ----------
@@ -465,11 +542,13 @@ func (h *handlers) GetMe(c echo.Context) error {
 }
 
 type GetRegisteredCourseResponseContent struct {
-	ID        string    `json:"id"`
-	Name      string    `json:"name"`
-	Teacher   string    `json:"teacher"`
-	Period    uint8     `json:"period"`
-	DayOfWeek DayOfWeek `json:"day_of_week"`
+	ID        string       `json:"id"`
+	Name      string       `json:"name"`
+	Teacher   string       `json:"teacher"`
+	Period    uint8        `json:"period"`
+	DayOfWeek DayOfWeek    `json:"day_of_week"`
+	TermID    *string      `json:"term_id"`
+	Slots     []CourseSlot `json:"slots"`
 }
 
 // GetRegisteredCourses GET /api/users/me/courses 履修中の科目一覧取得
@@ -492,7 +571,12 @@ func (h *handlers) GetRegisteredCourses(c echo.Context) error {
 		" FROM `courses`" +
 		" JOIN `registrations` ON `courses`.`id` = `registrations`.`course_id`" +
 		" WHERE `courses`.`status` != ? AND `registrations`.`user_id` = ?"
-	if err := tx.Select(&courses, query, StatusClosed, userID); err != nil {
+	args := []interface{}{StatusClosed, userID}
+	if termID := c.QueryParam("term_id"); termID != "" {
+		query += " AND `courses`.`term_id` = ?"
+		args = append(args, termID)
+	}
+	if err := tx.Select(&courses, query, args...); err != nil {
 		c.Logger().Error(err)
 		return c.NoContent(http.StatusInternalServerError)
 	}
@@ -505,6 +589,11 @@ func (h *handlers) GetRegisteredCourses(c echo.Context) error {
 			c.Logger().Error(err)
 			return c.NoContent(http.StatusInternalServerError)
 		}
+		slots, err := h.getCourseSlots(course.ID, course.primarySlot())
+		if err != nil {
+			c.Logger().Error(err)
+			return c.NoContent(http.StatusInternalServerError)
+		}
 
 		res = append(res, GetRegisteredCourseResponseContent{
 			ID:        course.ID,
@@ -512,6 +601,8 @@ func (h *handlers) GetRegisteredCourses(c echo.Context) error {
 			Teacher:   teacher.Name,
 			Period:    course.Period,
 			DayOfWeek: course.DayOfWeek,
+			TermID:    nullStringPtr(course.TermID),
+			Slots:     slots,
 		})
 	}
 
@@ -528,12 +619,21 @@ type RegisterCourseRequestContent struct {
 }
 
 type RegisterCoursesErrorResponse struct {
-	CourseNotFound       []string `json:"course_not_found,omitempty"`
-	NotRegistrableStatus []string `json:"not_registrable_status,omitempty"`
-	ScheduleConflict     []string `json:"schedule_conflict,omitempty"`
+	CourseNotFound       []string              `json:"course_not_found,omitempty"`
+	NotRegistrableStatus []string              `json:"not_registrable_status,omitempty"`
+	ScheduleConflict     []string              `json:"schedule_conflict,omitempty"`
+	CourseFull           []string              `json:"course_full,omitempty"`
+	NotDroppableStatus   []string              `json:"not_droppable_status,omitempty"`
+	PrerequisiteNotMet   []string              `json:"prerequisite_not_met,omitempty"`
+	CreditRuleViolation  []CreditRuleViolation `json:"credit_rule_violation,omitempty"`
+}
+
+func (e *RegisterCoursesErrorResponse) hasError() bool {
+	return len(e.CourseNotFound) > 0 || len(e.NotRegistrableStatus) > 0 || len(e.ScheduleConflict) > 0 || len(e.CourseFull) > 0 || len(e.NotDroppableStatus) > 0 || len(e.PrerequisiteNotMet) > 0 || len(e.CreditRuleViolation) > 0
 }
 
 // RegisterCourses PUT /api/users/me/courses 履修登録
+// mode=replace を指定した場合は、指定された科目の一覧がそのまま履修科目になるように登録と取り消しを行う
 func (h *handlers) RegisterCourses(c echo.Context) error {
 	userID, _, _, err := getUserInfo(c)
 	if err != nil {
@@ -548,6 +648,7 @@ func (h *handlers) RegisterCourses(c echo.Context) error {
 	sort.Slice(req, func(i, j int) bool {
 		return req[i].ID < req[j].ID
 	})
+	replace := c.QueryParam("mode") == "replace"
 
 	tx, err := h.DB.Beginx()
 	if err != nil {
@@ -556,57 +657,15 @@ func (h *handlers) RegisterCourses(c echo.Context) error {
 	}
 	defer tx.Rollback()
 
-	var errors RegisterCoursesErrorResponse
-	var newlyAdded []Course
-	for _, courseReq := range req {
-		courseID := courseReq.ID
-		ok, course := h.getCourse(courseID)
-		if !ok {
-			errors.CourseNotFound = append(errors.CourseNotFound, courseReq.ID)
-			continue
-		}
-
-		if course.Status != StatusRegistration {
-			errors.NotRegistrableStatus = append(errors.NotRegistrableStatus, course.ID)
-			continue
-		}
-
-		// すでに履修登録済みの科目は無視する
-		var count int
-		if err := tx.Get(&count, "SELECT COUNT(*) FROM `registrations` WHERE `course_id` = ? AND `user_id` = ? LIMIT 1", course.ID, userID); err != nil {
-			c.Logger().Error(err)
-			return c.NoContent(http.StatusInternalServerError)
-		}
-		if count > 0 {
-			continue
-		}
-
-		newlyAdded = append(newlyAdded, *course)
-	}
-
-	var alreadyRegistered []Course
-	query := "SELECT `courses`.*" +
-		" FROM `courses`" +
-		" JOIN `registrations` ON `courses`.`id` = `registrations`.`course_id`" +
-		" WHERE `courses`.`status` != ? AND `registrations`.`user_id` = ?"
-	if err := tx.Select(&alreadyRegistered, query, StatusClosed, userID); err != nil {
+	plan, err := h.planRegistration(tx, userID, req, replace, false)
+	if err != nil {
 		c.Logger().Error(err)
 		return c.NoContent(http.StatusInternalServerError)
 	}
-
-	alreadyRegistered = append(alreadyRegistered, newlyAdded...)
-	for _, course1 := range newlyAdded {
-		for _, course2 := range alreadyRegistered {
-			if course1.ID != course2.ID && course1.Period == course2.Period && course1.DayOfWeek == course2.DayOfWeek {
-				errors.ScheduleConflict = append(errors.ScheduleConflict, course1.ID)
-				break
-			}
-		}
-	}
-
-	if len(errors.CourseNotFound) > 0 || len(errors.NotRegistrableStatus) > 0 || len(errors.ScheduleConflict) > 0 {
-		return c.JSON(http.StatusBadRequest, errors)
+	if plan.Errors.hasError() {
+		return c.JSON(http.StatusBadRequest, plan.Errors)
 	}
+	newlyAdded, dropped := plan.NewlyAdded, plan.Dropped
 
 	for _, course := range newlyAdded {
 		h.SubDB.Exec("INSERT INTO `registrations` (`course_id`, `user_id`) VALUES (?, ?) ON DUPLICATE KEY UPDATE `course_id` = VALUES(`course_id`), `user_id` = VALUES(`user_id`)", course.ID, userID)
@@ -615,6 +674,25 @@ func (h *handlers) RegisterCourses(c echo.Context) error {
 			c.Logger().Error(err)
 			return c.NoContent(http.StatusInternalServerError)
 		}
+		if course.Capacity.Valid {
+			h.SubDB.Exec("DELETE FROM `waitlists` WHERE `course_id` = ? AND `user_id` = ?", course.ID, userID)
+			if _, err := tx.Exec("DELETE FROM `waitlists` WHERE `course_id` = ? AND `user_id` = ?", course.ID, userID); err != nil {
+				c.Logger().Error(err)
+				return c.NoContent(http.StatusInternalServerError)
+			}
+		}
+	}
+
+	var promoted []string
+	droppedIDs := make([]string, 0, len(dropped))
+	for i := range dropped {
+		p, err := h.dropRegistration(tx, userID, &dropped[i])
+		if err != nil {
+			c.Logger().Error(err)
+			return c.NoContent(http.StatusInternalServerError)
+		}
+		promoted = append(promoted, p...)
+		droppedIDs = append(droppedIDs, dropped[i].ID)
 	}
 
 	if err = tx.Commit(); err != nil {
@@ -622,20 +700,33 @@ func (h *handlers) RegisterCourses(c echo.Context) error {
 		return c.NoContent(http.StatusInternalServerError)
 	}
 
+	if len(dropped) > 0 {
+		afterRegistrationDropped(userID, droppedIDs, promoted)
+	} else if len(newlyAdded) > 0 {
+		afterRegistrationChanged(userID)
+	}
+
 	return c.NoContent(http.StatusOK)
 }
 
 type Class struct {
-	ID               string `db:"id"`
-	CourseID         string `db:"course_id"`
-	Part             uint8  `db:"part"`
-	Title            string `db:"title"`
-	Description      string `db:"description"`
-	SubmissionClosed bool   `db:"submission_closed"`
+	ID               string       `db:"id"`
+	CourseID         string       `db:"course_id"`
+	Part             uint8        `db:"part"`
+	Title            string       `db:"title"`
+	Description      string       `db:"description"`
+	SubmissionClosed bool         `db:"submission_closed"`
+	HeldOn           sql.NullTime `db:"held_on"`
+	Deadline         sql.NullTime `db:"deadline"`
+	GracePeriod      uint32       `db:"grace_period"`
+	LatePolicy       LatePolicy   `db:"late_policy"`
+	LatePenaltyRate  uint8        `db:"late_penalty_rate"`
+	LatePenaltyCap   uint8        `db:"late_penalty_cap"`
 }
 
 type GetGradeResponse struct {
 	Summary       Summary        `json:"summary"`
+	TermSummaries []TermSummary  `json:"terms"`
 	CourseResults []CourseResult `json:"courses"`
 }
 
@@ -657,14 +748,17 @@ type CourseResult struct {
 	TotalScoreMax    int          `json:"total_score_max"`     // 最大値
 	TotalScoreMin    int          `json:"total_score_min"`     // 最小値
 	ClassScores      []ClassScore `json:"class_scores"`
+	AttendanceRate   *float64     `json:"attendance_rate"` // 出席率(0~1)。出欠を取った講義がなければnull
 }
 
 type ClassScore struct {
 	ClassID    string `json:"class_id"`
 	Title      string `json:"title"`
 	Part       uint8  `json:"part"`
-	Score      *int   `json:"score"`      // 0~100点
+	Score      *int   `json:"score"`      // 0~100点(遅延提出の減点後)
 	Submitters int    `json:"submitters"` // 提出した学生数
+	Late       bool   `json:"late"`       // 遅延提出かどうか
+	Penalty    int    `json:"penalty"`    // 遅延提出による減点(%)
 }
 
 // GetGrades GET /api/users/me/grades 成績取得
@@ -677,7 +771,7 @@ func (h *handlers) GetGrades(c echo.Context) error {
 
 	// 履修している科目一覧取得
 	var registeredCourses []Course
-	query := "SELECT `courses`.`id`, `courses`.`name`, `courses`.`code`, `courses`.`credit`, `courses`.`status` FROM `registrations` JOIN `courses` ON `registrations`.`course_id` = `courses`.`id` WHERE `user_id` = ?"
+	query := "SELECT `courses`.`id`, `courses`.`name`, `courses`.`code`, `courses`.`credit`, `courses`.`status`, `courses`.`term_id` FROM `registrations` JOIN `courses` ON `registrations`.`course_id` = `courses`.`id` WHERE `user_id` = ?"
 	if err := h.Balance().Select(&registeredCourses, query, userID); err != nil {
 		c.Logger().Error(err)
 		return c.NoContent(http.StatusInternalServerError)
@@ -697,7 +791,7 @@ func (h *handlers) GetGrades(c echo.Context) error {
 
 	// 自分が参加した全class取得
 	var classes []Class
-	query = "SELECT id, course_id, part, title FROM `classes` WHERE `course_id` IN (" + sb.String() + ") ORDER BY `course_id`, `part` DESC"
+	query = "SELECT id, course_id, part, title, deadline, grace_period, late_policy, late_penalty_rate, late_penalty_cap FROM `classes` WHERE `course_id` IN (" + sb.String() + ") ORDER BY `course_id`, `part` DESC"
 	if err := h.Balance().Select(&classes, query); err != nil {
 		c.Logger().Error(err)
 		return c.NoContent(http.StatusInternalServerError)
@@ -720,19 +814,20 @@ func (h *handlers) GetGrades(c echo.Context) error {
 	}
 
 	// 自分が提出した全サブミッション取得
-	query = "SELECT `class_id`, `score` FROM `submissions` WHERE `user_id` = ?"
+	query = "SELECT `class_id`, `score`, `submitted_at` FROM `submissions` WHERE `user_id` = ?"
 	type scoreS struct {
-		ClassId string        `db:"class_id"`
-		Score   sql.NullInt64 `db:"score"`
+		ClassId     string        `db:"class_id"`
+		Score       sql.NullInt64 `db:"score"`
+		SubmittedAt time.Time     `db:"submitted_at"`
 	}
 	var myScores []scoreS
 	if err := h.Balance().Select(&myScores, query, userID); err != nil {
 		c.Logger().Error(err)
 		return c.NoContent(http.StatusInternalServerError)
 	}
-	myScoresMap := make(map[string]sql.NullInt64, len(myScores))
+	myScoresMap := make(map[string]scoreS, len(myScores))
 	for _, score := range myScores {
-		myScoresMap[score.ClassId] = score.Score
+		myScoresMap[score.ClassId] = score
 	}
 
 	// クラスの全サブミッション数
@@ -760,20 +855,45 @@ func (h *handlers) GetGrades(c echo.Context) error {
 		submissionsMap[sub.ClassId] = sub.Count
 	}
 
+	// 提出期限が延長された講義は延長後の期限で遅延提出を判定する
+	extensions, err := getDeadlineExtensions(h.Balance(), "", userID)
+	if err != nil {
+		c.Logger().Error(err)
+		return c.NoContent(http.StatusInternalServerError)
+	}
+
+	// 科目毎の自分の出席率
+	courseIDs := make([]string, 0, len(registeredCourses))
+	for _, course := range registeredCourses {
+		courseIDs = append(courseIDs, course.ID)
+	}
+	attendance, err := getAttendanceStats(h.Balance(), courseIDs, userID)
+	if err != nil {
+		c.Logger().Error(err)
+		return c.NoContent(http.StatusInternalServerError)
+	}
+
 	myTotalScores := map[string]int{}
 	classScores := make(map[string][]ClassScore, len(classes))
 	for _, class := range classes {
-		myScore := myScoresMap[class.ID]
-		if !myScore.Valid {
+		myScore, submitted := myScoresMap[class.ID]
+		var late bool
+		var penalty int
+		if submitted {
+			late, penalty = class.withExtension(extensions, userID).latePenalty(myScore.SubmittedAt)
+		}
+		if !myScore.Score.Valid {
 			classScores[class.CourseID] = append(classScores[class.CourseID], ClassScore{
 				ClassID:    class.ID,
 				Part:       class.Part,
 				Title:      class.Title,
 				Score:      nil,
 				Submitters: submissionsMap[class.ID],
+				Late:       late,
+				Penalty:    penalty,
 			})
 		} else {
-			score := int(myScore.Int64)
+			score := applyPenalty(int(myScore.Score.Int64), penalty)
 			myTotalScores[class.CourseID] += score
 			classScores[class.CourseID] = append(classScores[class.CourseID], ClassScore{
 				ClassID:    class.ID,
@@ -781,6 +901,8 @@ func (h *handlers) GetGrades(c echo.Context) error {
 				Title:      class.Title,
 				Score:      &score,
 				Submitters: submissionsMap[class.ID],
+				Late:       late,
+				Penalty:    penalty,
 			})
 		}
 	}
@@ -801,6 +923,7 @@ func (h *handlers) GetGrades(c echo.Context) error {
 			TotalScoreMax:    maxInt(totals, 0),
 			TotalScoreMin:    minInt(totals, 0),
 			ClassScores:      classScores[course.ID],
+			AttendanceRate:   attendance.summary(course.ID, userID).Rate,
 		})
 
 		// 自分のGPA計算
@@ -822,6 +945,8 @@ func (h *handlers) GetGrades(c echo.Context) error {
 	}
 
 	res := GetGradeResponse{
+		// 学期毎のGPA (Summaryは全学期通算)
+		TermSummaries: h.calcTermSummaries(registeredCourses, myTotalScores),
 		Summary: Summary{
 			Credits:   myCredits,
 			GPA:       myGPA,
@@ -843,7 +968,8 @@ func (h *handlers) SearchCourses(c echo.Context) error {
 	query := "SELECT `courses`.*, `users`.`name` AS `teacher`" +
 		" FROM `courses` JOIN `users` ON `courses`.`teacher_id` = `users`.`id`" +
 		" WHERE 1=1"
-	var condition string
+	// アーカイブされた科目は検索結果に含めない
+	condition := " AND `courses`.`archived_at` IS NULL"
 	var args []interface{}
 
 	// 無効な検索条件はエラーを返さず無視して良い
@@ -858,34 +984,38 @@ func (h *handlers) SearchCourses(c echo.Context) error {
 		args = append(args, credit)
 	}
 
+	// 共同担当を含むいずれかの担当教員の名前が一致すれば良い
 	if teacher := c.QueryParam("teacher"); teacher != "" {
-		condition += " AND `users`.`name` = ?"
+		condition += " AND EXISTS (SELECT 1 FROM `course_staff` JOIN `users` AS `staff` ON `staff`.`id` = `course_staff`.`user_id`" +
+			" WHERE `course_staff`.`course_id` = `courses`.`id` AND `staff`.`name` = ?)"
 		args = append(args, teacher)
 	}
 
+	// 時限はいずれかの開講時限が一致すれば良い
+	var slotCondition string
 	if period, err := strconv.Atoi(c.QueryParam("period")); err == nil && period > 0 {
-		condition += " AND `courses`.`period` = ?"
+		slotCondition += " AND `course_slots`.`period` = ?"
 		args = append(args, period)
 	}
 
 	if dayOfWeek := c.QueryParam("day_of_week"); dayOfWeek != "" {
-		condition += " AND `courses`.`day_of_week` = ?"
+		slotCondition += " AND `course_slots`.`day_of_week` = ?"
 		args = append(args, dayOfWeek)
 	}
 
+	if slotCondition != "" {
+		condition += " AND EXISTS (SELECT 1 FROM `course_slots` WHERE `course_slots`.`course_id` = `courses`.`id`" + slotCondition + ")"
+	}
+
+	// キーワードは科目名・キーワード・説明を全文検索し、関連度の高い順に並べる
+	var relevance string
+	var relevanceArgs []interface{}
 	if keywords := c.QueryParam("keywords"); keywords != "" {
-		arr := strings.Split(keywords, " ")
-		var nameCondition string
-		for _, keyword := range arr {
-			nameCondition += " AND `courses`.`name` LIKE ?"
-			args = append(args, "%"+keyword+"%")
-		}
 		var keywordsCondition string
-		for _, keyword := range arr {
-			keywordsCondition += " AND `courses`.`keywords` LIKE ?"
-			args = append(args, "%"+keyword+"%")
-		}
-		condition += fmt.Sprintf(" AND ((1=1%s) OR (1=1%s))", nameCondition, keywordsCondition)
+		var keywordsArgs []interface{}
+		keywordsCondition, keywordsArgs, relevance, relevanceArgs = parseSearchQuery(keywords).buildCondition()
+		condition += keywordsCondition
+		args = append(args, keywordsArgs...)
 	}
 
 	if status := c.QueryParam("status"); status != "" {
@@ -893,22 +1023,42 @@ func (h *handlers) SearchCourses(c echo.Context) error {
 		args = append(args, status)
 	}
 
-	condition += " ORDER BY `courses`.`code`"
+	if termID := c.QueryParam("term_id"); termID != "" {
+		condition += " AND `courses`.`term_id` = ?"
+		args = append(args, termID)
+	}
+
+	// 総数・ファセットの集計には並び替え・ページングより前の条件を使う
+	filter := condition
+	filterArgs := append([]interface{}{}, args...)
 
-	var page int
-	if c.QueryParam("page") == "" {
-		page = 1
+	page, cursor, err := parsePagination(c)
+	if err != nil {
+		return c.String(http.StatusBadRequest, err.Error())
+	}
+
+	// 科目コード順の場合はkeyset方式でページングする。関連度順の場合はオフセットを使う
+	keyset := relevance == ""
+	var reversed bool
+	offset := cursor.Offset
+	if keyset {
+		keysetCondition, order, keysetArgs, rev := cursor.keyset("`courses`.`code`", false)
+		condition += keysetCondition + " ORDER BY " + order
+		args = append(args, keysetArgs...)
+		reversed = rev
+		if cursor.isKeyset() {
+			offset = 0
+		}
 	} else {
-		var err error
-		page, err = strconv.Atoi(c.QueryParam("page"))
-		if err != nil || page <= 0 {
-			return c.String(http.StatusBadRequest, "Invalid page.")
+		if cursor.isKeyset() {
+			return c.String(http.StatusBadRequest, "Invalid cursor.")
 		}
+		condition += " ORDER BY " + relevance + " DESC, `courses`.`code`"
+		args = append(args, relevanceArgs...)
 	}
-	limit := 20
-	offset := limit * (page - 1)
 
 	// limitより多く上限を設定し、実際にlimitより多くレコードが取得できた場合は次のページが存在する
+	limit := pageLimit
 	condition += " LIMIT ? OFFSET ?"
 	args = append(args, limit+1, offset)
 
@@ -919,44 +1069,73 @@ func (h *handlers) SearchCourses(c echo.Context) error {
 		return c.NoContent(http.StatusInternalServerError)
 	}
 
-	var links []string
-	linkURL, err := url.Parse(c.Request().URL.Path + "?" + c.Request().URL.RawQuery)
-	if err != nil {
-		c.Logger().Error(err)
-		return c.NoContent(http.StatusInternalServerError)
+	hasMore := len(res) > limit
+	if hasMore {
+		res = res[:limit]
+	}
+	if reversed {
+		for i, j := 0, len(res)-1; i < j; i, j = i+1, j-1 {
+			res[i], res[j] = res[j], res[i]
+		}
 	}
 
-	q := linkURL.Query()
-	if page > 1 {
-		q.Set("page", strconv.Itoa(page-1))
-		linkURL.RawQuery = q.Encode()
-		links = append(links, fmt.Sprintf("<%v>; rel=\"prev\"", linkURL))
+	var firstKey, lastKey string
+	if len(res) > 0 {
+		firstKey, lastKey = res[0].Code, res[len(res)-1].Code
 	}
-	if len(res) > limit {
-		q.Set("page", strconv.Itoa(page+1))
-		linkURL.RawQuery = q.Encode()
-		links = append(links, fmt.Sprintf("<%v>; rel=\"next\"", linkURL))
+	prev, next := adjacentCursors(cursor, keyset, hasMore, firstKey, lastKey)
+	if err := setPaginationLinks(c, page, prev, next); err != nil {
+		c.Logger().Error(err)
+		return c.NoContent(http.StatusInternalServerError)
 	}
-	if len(links) > 0 {
-		c.Response().Header().Set("Link", strings.Join(links, ","))
+
+	for i := range res {
+		slots, err := h.getCourseSlots(res[i].ID, res[i].primarySlot())
+		if err != nil {
+			c.Logger().Error(err)
+			return c.NoContent(http.StatusInternalServerError)
+		}
+		res[i].Slots = slots
+
+		staff, err := h.getCourseStaff(res[i].ID)
+		if err != nil {
+			c.Logger().Error(err)
+			return c.NoContent(http.StatusInternalServerError)
+		}
+		res[i].Staff = staff
 	}
 
-	if len(res) == limit+1 {
-		res = res[:len(res)-1]
+	// facets=true の時は総数とファセットを合わせて返す
+	if withFacets, _ := strconv.ParseBool(c.QueryParam("facets")); withFacets {
+		total, facets, err := h.searchFacets(filter, filterArgs)
+		if err != nil {
+			c.Logger().Error(err)
+			return c.NoContent(http.StatusInternalServerError)
+		}
+		return c.JSON(http.StatusOK, SearchCoursesResponse{
+			Courses: res,
+			Total:   total,
+			Facets:  *facets,
+		})
 	}
 
 	return c.JSON(http.StatusOK, res)
 }
 
 type AddCourseRequest struct {
-	Code        string     `json:"code"`
-	Type        CourseType `json:"type"`
-	Name        string     `json:"name"`
-	Description string     `json:"description"`
-	Credit      int        `json:"credit"`
-	Period      int        `json:"period"`
-	DayOfWeek   DayOfWeek  `json:"day_of_week"`
-	Keywords    string     `json:"keywords"`
+	Code          string         `json:"code"`
+	Type          CourseType     `json:"type"`
+	Name          string         `json:"name"`
+	Description   string         `json:"description"`
+	Credit        int            `json:"credit"`
+	Period        int            `json:"period"`
+	DayOfWeek     DayOfWeek      `json:"day_of_week"`
+	Keywords      string         `json:"keywords"`
+	TermID        string         `json:"term_id"`
+	Capacity      int            `json:"capacity"` // 0は定員なし
+	Prerequisites []Prerequisite `json:"prerequisites"`
+	// 複数の時限を指定する場合。省略時はperiod, day_of_weekの一つのみ
+	Slots []CourseSlot `json:"slots"`
 }
 
 type AddCourseResponse struct {
@@ -1003,9 +1182,31 @@ func (h *handlers) AddCourse(c echo.Context) error {
 	if req.Type != LiberalArts && req.Type != MajorSubjects {
 		return c.String(http.StatusBadRequest, "Invalid course type.")
 	}
+	if len(req.Slots) > 0 {
+		if !validateSlots(req.Slots) {
+			return c.String(http.StatusBadRequest, "Invalid slots.")
+		}
+		sortSlots(req.Slots)
+		req.DayOfWeek, req.Period = req.Slots[0].DayOfWeek, int(req.Slots[0].Period)
+	} else {
+		req.Slots = []CourseSlot{{DayOfWeek: req.DayOfWeek, Period: uint8(req.Period)}}
+	}
 	if !contains(daysOfWeek, req.DayOfWeek) {
 		return c.String(http.StatusBadRequest, "Invalid day of week.")
 	}
+	termID := sql.NullString{String: req.TermID, Valid: req.TermID != ""}
+	if termID.Valid {
+		if ok, _ := h.getTerm(termID.String); !ok {
+			return c.String(http.StatusBadRequest, "No such term.")
+		}
+	}
+	if req.Capacity < 0 {
+		return c.String(http.StatusBadRequest, "Invalid capacity.")
+	}
+	capacity := sql.NullInt32{Int32: int32(req.Capacity), Valid: req.Capacity > 0}
+	if !validatePrerequisites(req.Code, req.Prerequisites) {
+		return c.String(http.StatusBadRequest, "Invalid prerequisites.")
+	}
 
 	courseID := newULID()
 	course := &Course{
@@ -1020,12 +1221,22 @@ func (h *handlers) AddCourse(c echo.Context) error {
 		TeacherID:   userID,
 		Keywords:    req.Keywords,
 		Status:      StatusRegistration,
+		TermID:      termID,
+		Capacity:    capacity,
 	}
 
-	_, err = h.SubDB.Exec("INSERT INTO `courses` (`id`, `code`, `type`, `name`, `description`, `credit`, `period`, `day_of_week`, `teacher_id`, `keywords`) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)",
-		courseID, req.Code, req.Type, req.Name, req.Description, req.Credit, req.Period, req.DayOfWeek, userID, req.Keywords)
-	_, err = h.DB.Exec("INSERT INTO `courses` (`id`, `code`, `type`, `name`, `description`, `credit`, `period`, `day_of_week`, `teacher_id`, `keywords`) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)",
-		courseID, req.Code, req.Type, req.Name, req.Description, req.Credit, req.Period, req.DayOfWeek, userID, req.Keywords)
+	// 時限・担当教員などが欠けた科目が残らないよう、科目と合わせて一つのトランザクションで登録する
+	tx, err := h.DB.Beginx()
+	if err != nil {
+		c.Logger().Error(err)
+		return c.NoContent(http.StatusInternalServerError)
+	}
+	defer tx.Rollback()
+
+	_, err = h.SubDB.Exec("INSERT INTO `courses` (`id`, `code`, `type`, `name`, `description`, `credit`, `period`, `day_of_week`, `teacher_id`, `keywords`, `term_id`, `capacity`) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)",
+		courseID, req.Code, req.Type, req.Name, req.Description, req.Credit, req.Period, req.DayOfWeek, userID, req.Keywords, termID, capacity)
+	_, err = tx.Exec("INSERT INTO `courses` (`id`, `code`, `type`, `name`, `description`, `credit`, `period`, `day_of_week`, `teacher_id`, `keywords`, `term_id`, `capacity`) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)",
+		courseID, req.Code, req.Type, req.Name, req.Description, req.Credit, req.Period, req.DayOfWeek, userID, req.Keywords, termID, capacity)
 	if err != nil {
 		if mysqlErr, ok := err.(*mysql.MySQLError); ok && mysqlErr.Number == uint16(mysqlErrNumDuplicateEntry) {
 			var course Course
@@ -1042,26 +1253,308 @@ func (h *handlers) AddCourse(c echo.Context) error {
 		return c.NoContent(http.StatusInternalServerError)
 	}
 
+	if err := h.insertPrerequisites(tx, h.SubDB, courseID, req.Prerequisites); err != nil {
+		c.Logger().Error(err)
+		return c.NoContent(http.StatusInternalServerError)
+	}
+	if err := h.insertCourseSlots(tx, h.SubDB, courseID, req.Slots); err != nil {
+		c.Logger().Error(err)
+		return c.NoContent(http.StatusInternalServerError)
+	}
+	if err := h.insertCourseStaff(tx, h.SubDB, courseID, userID, RoleOwner); err != nil {
+		c.Logger().Error(err)
+		return c.NoContent(http.StatusInternalServerError)
+	}
+	if _, err := h.addSyllabusRevision(tx, h.SubDB, course, userID); err != nil {
+		c.Logger().Error(err)
+		return c.NoContent(http.StatusInternalServerError)
+	}
+
+	if err := tx.Commit(); err != nil {
+		c.Logger().Error(err)
+		return c.NoContent(http.StatusInternalServerError)
+	}
+
+	cachePrerequisites(courseID, req.Prerequisites)
+	cacheCourseSlots(courseID, req.Slots)
 	CourseCacheMux.Lock()
 	CourseCacheMap[courseID] = course
 	CourseCacheMux.Unlock()
 
+	bumpVersion(catalogKey, courseKey(courseID))
 	return c.JSON(http.StatusCreated, AddCourseResponse{ID: courseID})
 }
 
+type UpdateCourseRequest struct {
+	Type          *CourseType     `json:"type"`
+	Name          *string         `json:"name"`
+	Description   *string         `json:"description"`
+	Credit        *int            `json:"credit"`
+	Period        *int            `json:"period"`
+	DayOfWeek     *DayOfWeek      `json:"day_of_week"`
+	Keywords      *string         `json:"keywords"`
+	TermID        *string         `json:"term_id"`
+	Capacity      *int            `json:"capacity"` // 0は定員なし
+	Prerequisites *[]Prerequisite `json:"prerequisites"`
+	Slots         *[]CourseSlot   `json:"slots"`
+	Version       *uint32         `json:"version"`
+}
+
+type UpdateCourseResponse struct {
+	ID      string `json:"id"`
+	Version uint32 `json:"version"`
+}
+
+// parseIfMatchVersion If-Matchヘッダ("3" や W/"3")から科目のバージョンを取り出す
+// 科目詳細のETag("3-<hash>")もそのまま受け付ける
+func parseIfMatchVersion(header string) (uint32, bool) {
+	header = strings.TrimPrefix(strings.TrimSpace(header), "W/")
+	header = strings.Trim(header, "\"")
+	if i := strings.IndexByte(header, '-'); i >= 0 {
+		header = header[:i]
+	}
+	v, err := strconv.ParseUint(header, 10, 32)
+	if err != nil {
+		return 0, false
+	}
+	return uint32(v), true
+}
+
+// UpdateCourse PATCH /api/courses/:courseID 科目情報の部分更新
+func (h *handlers) UpdateCourse(c echo.Context) error {
+	userID, _, _, err := getUserInfo(c)
+	if err != nil {
+		c.Logger().Error(err)
+		return c.NoContent(http.StatusInternalServerError)
+	}
+
+	courseID := c.Param("courseID")
+
+	var req UpdateCourseRequest
+	if err := c.Bind(&req); err != nil {
+		return c.String(http.StatusBadRequest, "Invalid format.")
+	}
+
+	// バージョンはIf-Matchヘッダかリクエストボディで指定する
+	var version uint32
+	if ifMatch := c.Request().Header.Get("If-Match"); ifMatch != "" {
+		v, ok := parseIfMatchVersion(ifMatch)
+		if !ok {
+			return c.String(http.StatusBadRequest, "Invalid If-Match header.")
+		}
+		version = v
+	} else if req.Version != nil {
+		version = *req.Version
+	} else {
+		return c.String(http.StatusPreconditionRequired, "Version of the course is required.")
+	}
+
+	ok, current := h.getCourse(courseID)
+	if !ok {
+		return c.String(http.StatusNotFound, "No such course.")
+	}
+	// 共同担当の教員も科目情報を更新できる
+	isStaff, err := h.isCourseStaff(courseID, userID)
+	if err != nil {
+		c.Logger().Error(err)
+		return c.NoContent(http.StatusInternalServerError)
+	}
+	if !isStaff {
+		return c.String(http.StatusForbidden, "You are not the teacher of this course.")
+	}
+
+	course := *current
+	if req.Type != nil {
+		if *req.Type != LiberalArts && *req.Type != MajorSubjects {
+			return c.String(http.StatusBadRequest, "Invalid course type.")
+		}
+		course.Type = *req.Type
+	}
+	if req.Name != nil {
+		if *req.Name == "" || utf8.RuneCountInString(*req.Name) > 255 {
+			return c.String(http.StatusBadRequest, "Invalid course name.")
+		}
+		course.Name = *req.Name
+	}
+	if req.Description != nil {
+		course.Description = *req.Description
+	}
+	if req.Credit != nil {
+		if *req.Credit <= 0 || *req.Credit > 255 {
+			return c.String(http.StatusBadRequest, "Invalid credit.")
+		}
+		course.Credit = uint8(*req.Credit)
+	}
+	if req.Period != nil {
+		if *req.Period <= 0 || *req.Period > periodCount {
+			return c.String(http.StatusBadRequest, "Invalid period.")
+		}
+		course.Period = uint8(*req.Period)
+	}
+	if req.DayOfWeek != nil {
+		if !contains(daysOfWeek, *req.DayOfWeek) {
+			return c.String(http.StatusBadRequest, "Invalid day of week.")
+		}
+		course.DayOfWeek = *req.DayOfWeek
+	}
+	if req.Keywords != nil {
+		course.Keywords = *req.Keywords
+	}
+	if req.TermID != nil {
+		if *req.TermID != "" {
+			if ok, _ := h.getTerm(*req.TermID); !ok {
+				return c.String(http.StatusBadRequest, "No such term.")
+			}
+		}
+		course.TermID = sql.NullString{String: *req.TermID, Valid: *req.TermID != ""}
+	}
+	if req.Capacity != nil {
+		if *req.Capacity < 0 {
+			return c.String(http.StatusBadRequest, "Invalid capacity.")
+		}
+		course.Capacity = sql.NullInt32{Int32: int32(*req.Capacity), Valid: *req.Capacity > 0}
+	}
+	if req.Prerequisites != nil && !validatePrerequisites(course.Code, *req.Prerequisites) {
+		return c.String(http.StatusBadRequest, "Invalid prerequisites.")
+	}
+	// 時限の一覧を指定した場合は最初の時限を代表とする。period, day_of_weekのみの場合は時限が一つの科目に限る
+	currentSlots, err := h.getCourseSlots(courseID, current.primarySlot())
+	if err != nil {
+		c.Logger().Error(err)
+		return c.NoContent(http.StatusInternalServerError)
+	}
+	var slots []CourseSlot
+	if req.Slots != nil {
+		slots = append(slots, *req.Slots...)
+		if !validateSlots(slots) {
+			return c.String(http.StatusBadRequest, "Invalid slots.")
+		}
+		sortSlots(slots)
+		course.DayOfWeek, course.Period = slots[0].DayOfWeek, slots[0].Period
+	} else if req.Period != nil || req.DayOfWeek != nil {
+		if len(currentSlots) > 1 {
+			return c.String(http.StatusBadRequest, "Specify slots to change the schedule of a course with multiple slots.")
+		}
+		slots = []CourseSlot{course.primarySlot()}
+	}
+	if slots != nil && equalSlots(slots, currentSlots) {
+		slots = nil
+	}
+
+	// 履修登録期間が終わった後は、履修者に影響する項目は変更できない
+	if current.Status != StatusRegistration {
+		if course.Type != current.Type || course.Credit != current.Credit || course.Period != current.Period || course.DayOfWeek != current.DayOfWeek || slots != nil || course.TermID != current.TermID || course.Capacity != current.Capacity || req.Prerequisites != nil {
+			return c.String(http.StatusBadRequest, "Type, credit, schedule, term, capacity and prerequisites cannot be changed after the registration period.")
+		}
+	}
+
+	tx, err := h.DB.Beginx()
+	if err != nil {
+		c.Logger().Error(err)
+		return c.NoContent(http.StatusInternalServerError)
+	}
+	defer tx.Rollback()
+
+	query := "UPDATE `courses` SET `type` = ?, `name` = ?, `description` = ?, `credit` = ?, `period` = ?, `day_of_week` = ?, `keywords` = ?, `term_id` = ?, `capacity` = ?, `version` = `version` + 1 WHERE `id` = ? AND `version` = ?"
+	args := []interface{}{course.Type, course.Name, course.Description, course.Credit, course.Period, course.DayOfWeek, course.Keywords, course.TermID, course.Capacity, courseID, version}
+	r, err := tx.Exec(query, args...)
+	if err != nil {
+		c.Logger().Error(err)
+		return c.NoContent(http.StatusInternalServerError)
+	}
+	if affected, _ := r.RowsAffected(); affected == 0 {
+		return c.String(http.StatusConflict, "The course has been modified by someone else.")
+	}
+	if _, err := h.SubDB.Exec(query, args...); err != nil {
+		c.Logger().Error(err)
+		return c.NoContent(http.StatusInternalServerError)
+	}
+
+	if req.Prerequisites != nil {
+		if err := h.setPrerequisites(tx, h.SubDB, courseID, *req.Prerequisites); err != nil {
+			c.Logger().Error(err)
+			return c.NoContent(http.StatusInternalServerError)
+		}
+	}
+
+	if slots != nil {
+		if err := h.setCourseSlots(tx, h.SubDB, courseID, slots); err != nil {
+			c.Logger().Error(err)
+			return c.NoContent(http.StatusInternalServerError)
+		}
+	}
+
+	// 定員が増えた場合はキャンセル待ちの学生を繰り上げる
+	var promoted []string
+	if current.Capacity.Valid && (!course.Capacity.Valid || course.Capacity.Int32 > current.Capacity.Int32) {
+		promoted, err = h.promoteWaitlist(tx, &course)
+		if err != nil {
+			c.Logger().Error(err)
+			return c.NoContent(http.StatusInternalServerError)
+		}
+	}
+
+	// シラバス(説明・キーワード)が変わった場合は版を記録し、履修者に通知する
+	var notified []string
+	if course.Description != current.Description || course.Keywords != current.Keywords {
+		notified, err = h.recordSyllabusChange(tx, &course, userID)
+		if err != nil {
+			c.Logger().Error(err)
+			return c.NoContent(http.StatusInternalServerError)
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		c.Logger().Error(err)
+		return c.NoContent(http.StatusInternalServerError)
+	}
+	afterRegistrationChanged(promoted...)
+	bumpAnnouncements(notified)
+	if req.Prerequisites != nil {
+		cachePrerequisites(courseID, *req.Prerequisites)
+	}
+	if slots != nil {
+		cacheCourseSlots(courseID, slots)
+	}
+
+	course.Version = version + 1
+	CourseCacheMux.Lock()
+	CourseCacheMap[courseID] = &course
+	CourseCacheMux.Unlock()
+
+	if course.Name != current.Name {
+		discardAnnouncementDetailsByCourse(courseID)
+	}
+
+	bumpVersion(catalogKey, courseKey(courseID))
+	return c.JSON(http.StatusOK, UpdateCourseResponse{ID: courseID, Version: course.Version})
+}
+
 type GetCourseDetailResponse struct {
-	ID          string       `json:"id" db:"id"`
-	Code        string       `json:"code" db:"code"`
-	Type        string       `json:"type" db:"type"`
-	Name        string       `json:"name" db:"name"`
-	Description string       `json:"description" db:"description"`
-	Credit      uint8        `json:"credit" db:"credit"`
-	Period      uint8        `json:"period" db:"period"`
-	DayOfWeek   string       `json:"day_of_week" db:"day_of_week"`
-	TeacherID   string       `json:"-" db:"teacher_id"`
-	Keywords    string       `json:"keywords" db:"keywords"`
-	Status      CourseStatus `json:"status" db:"status"`
-	Teacher     string       `json:"teacher" db:"teacher"`
+	ID          string        `json:"id" db:"id"`
+	Code        string        `json:"code" db:"code"`
+	Type        string        `json:"type" db:"type"`
+	Name        string        `json:"name" db:"name"`
+	Description string        `json:"description" db:"description"`
+	Credit      uint8         `json:"credit" db:"credit"`
+	Period      uint8         `json:"period" db:"period"`
+	DayOfWeek   string        `json:"day_of_week" db:"day_of_week"`
+	TeacherID   string        `json:"-" db:"teacher_id"`
+	Keywords    string        `json:"keywords" db:"keywords"`
+	Status      CourseStatus  `json:"status" db:"status"`
+	Version     uint32        `json:"version" db:"version"`
+	TermID      *string       `json:"term_id" db:"term_id"`
+	Capacity    *int32        `json:"capacity" db:"capacity"`
+	ArchivedAt  *time.Time    `json:"archived_at" db:"archived_at"`
+	Teacher     string        `json:"teacher" db:"teacher"`
+	Slots       []CourseSlot  `json:"slots" db:"-"`
+	Staff       []CourseStaff `json:"staff" db:"-"`
+	// 科目詳細でのみ返す
+	Prerequisites []Prerequisite `json:"prerequisites,omitempty" db:"-"`
+}
+
+func (res *GetCourseDetailResponse) primarySlot() CourseSlot {
+	return CourseSlot{DayOfWeek: DayOfWeek(res.DayOfWeek), Period: res.Period}
 }
 
 // GetCourseDetail GET /api/courses/:courseID 科目詳細の取得
@@ -1080,11 +1573,33 @@ func (h *handlers) GetCourseDetail(c echo.Context) error {
 		return c.String(http.StatusNotFound, "No such course.")
 	}
 
+	prerequisites, err := h.getPrerequisites(courseID)
+	if err != nil {
+		c.Logger().Error(err)
+		return c.NoContent(http.StatusInternalServerError)
+	}
+	res.Prerequisites = prerequisites
+
+	slots, err := h.getCourseSlots(courseID, res.primarySlot())
+	if err != nil {
+		c.Logger().Error(err)
+		return c.NoContent(http.StatusInternalServerError)
+	}
+	res.Slots = slots
+
+	staff, err := h.getCourseStaff(courseID)
+	if err != nil {
+		c.Logger().Error(err)
+		return c.NoContent(http.StatusInternalServerError)
+	}
+	res.Staff = staff
+
 	return c.JSON(http.StatusOK, res)
 }
 
 type SetCourseStatusRequest struct {
 	Status CourseStatus `json:"status"`
+	Reopen bool         `json:"reopen"`
 }
 
 // SetCourseStatus PUT /api/courses/:courseID/status 科目のステータスを変更
@@ -1096,11 +1611,23 @@ func (h *handlers) SetCourseStatus(c echo.Context) error {
 		return c.String(http.StatusBadRequest, "Invalid format.")
 	}
 
+	if !isValidCourseStatus(req.Status) {
+		return c.String(http.StatusBadRequest, "Invalid course status.")
+	}
+
 	ok, course := h.getCourse(courseID)
 	if !ok {
 		return c.String(http.StatusNotFound, "No such course.")
 	}
 
+	// 同じステータスへの変更は何もしない
+	if course.Status == req.Status {
+		return c.NoContent(http.StatusOK)
+	}
+	if !canTransitCourseStatus(course.Status, req.Status, req.Reopen) {
+		return c.String(http.StatusBadRequest, fmt.Sprintf("Cannot change status from %s to %s.", course.Status, req.Status))
+	}
+
 	tx, err := h.DB.Beginx()
 	if err != nil {
 		c.Logger().Error(err)
@@ -1108,11 +1635,21 @@ func (h *handlers) SetCourseStatus(c echo.Context) error {
 	}
 	defer tx.Rollback()
 
+	// 並行して遷移された場合に備えて、遷移元のステータスも条件に含める
+	r, err := tx.Exec("UPDATE `courses` SET `status` = ? WHERE `id` = ? AND `status` = ?", req.Status, courseID, course.Status)
+	if err != nil {
+		c.Logger().Error(err)
+		return c.NoContent(http.StatusInternalServerError)
+	}
+	if affected, _ := r.RowsAffected(); affected == 0 {
+		return c.String(http.StatusConflict, "The course status has been changed by someone else.")
+	}
 	if _, err := h.SubDB.Exec("UPDATE `courses` SET `status` = ? WHERE `id` = ?", req.Status, courseID); err != nil {
 		c.Logger().Error(err)
 		return c.NoContent(http.StatusInternalServerError)
 	}
-	if _, err := tx.Exec("UPDATE `courses` SET `status` = ? WHERE `id` = ?", req.Status, courseID); err != nil {
+
+	if err := h.runCourseStatusHooks(tx, course, req.Status); err != nil {
 		c.Logger().Error(err)
 		return c.NoContent(http.StatusInternalServerError)
 	}
@@ -1122,27 +1659,31 @@ func (h *handlers) SetCourseStatus(c echo.Context) error {
 		return c.NoContent(http.StatusInternalServerError)
 	}
 	course.Status = req.Status
+	h.runCourseStatusAfterHooks(course, req.Status)
 
+	bumpVersion(catalogKey, courseKey(courseID), gradesKey)
 	return c.NoContent(http.StatusOK)
 }
 
 type ClassWithSubmitted struct {
-	ID               string `db:"id"`
-	CourseID         string `db:"course_id"`
-	Part             uint8  `db:"part"`
-	Title            string `db:"title"`
-	Description      string `db:"description"`
-	SubmissionClosed bool   `db:"submission_closed"`
-	Submitted        bool   `db:"submitted"`
+	Class
+	Submitted bool `db:"submitted"`
 }
 
 type GetClassResponse struct {
-	ID               string `json:"id"`
-	Part             uint8  `json:"part"`
-	Title            string `json:"title"`
-	Description      string `json:"description"`
-	SubmissionClosed bool   `json:"submission_closed"`
-	Submitted        bool   `json:"submitted"`
+	ID               string     `json:"id"`
+	Part             uint8      `json:"part"`
+	Title            string     `json:"title"`
+	Description      string     `json:"description"`
+	SubmissionClosed bool       `json:"submission_closed"`
+	HeldOn           *string    `json:"held_on,omitempty"`
+	Deadline         *time.Time `json:"deadline,omitempty"`
+	// 提出期限が学生個別に延長されているか
+	Extended bool `json:"extended"`
+	// 遅延提出を受け付ける場合の締め切り日時(提出期限 + 猶予期間)
+	ClosesAt   *time.Time `json:"closes_at,omitempty"`
+	LatePolicy LatePolicy `json:"late_policy"`
+	Submitted  bool       `json:"submitted"`
 }
 
 var (
@@ -1173,11 +1714,6 @@ func (h *handlers) GetClasses(c echo.Context) error {
 
 	courseID := c.Param("courseID")
 
-	etag := h.getClassesEtag(courseID, userID)
-	if etag != "" && c.Request().Header.Get("If-None-Match") == etag {
-		return c.NoContent(http.StatusNotModified)
-	}
-
 	ok, _ := h.getCourse(courseID)
 	if !ok {
 		return c.String(http.StatusNotFound, "No such course.")
@@ -1193,20 +1729,34 @@ func (h *handlers) GetClasses(c echo.Context) error {
 		return c.NoContent(http.StatusInternalServerError)
 	}
 
+	// 提出期限が延長されていれば延長後の期限を返す
+	extensions, err := getDeadlineExtensions(h.Balance(), courseID, userID)
+	if err != nil {
+		c.Logger().Error(err)
+		return c.NoContent(http.StatusInternalServerError)
+	}
+
 	// 結果が0件の時は空配列を返却
+	now := time.Now()
 	res := make([]GetClassResponse, 0, len(classes))
 	for _, class := range classes {
+		effective := class.withExtension(extensions, userID)
 		res = append(res, GetClassResponse{
-			ID:               class.ID,
-			Part:             class.Part,
-			Title:            class.Title,
-			Description:      class.Description,
-			SubmissionClosed: class.SubmissionClosed,
+			ID:          class.ID,
+			Part:        class.Part,
+			Title:       class.Title,
+			Description: class.Description,
+			// スケジューラが締め切るまでの間も期限を過ぎていれば締め切り済みとして返す
+			SubmissionClosed: effective.isSubmissionClosed(now),
+			HeldOn:           nullDatePtr(class.HeldOn),
+			Deadline:         nullTimePtr(effective.Deadline),
+			Extended:         effective != &class.Class,
+			ClosesAt:         closesAtPtr(effective),
+			LatePolicy:       class.LatePolicy,
 			Submitted:        h.isSubmit(class.ID, userID),
 		})
 	}
 
-	c.Response().Header().Set("ETag", h.setClassesEtag(courseID, userID))
 	return c.JSON(http.StatusOK, res)
 }
 
@@ -1214,6 +1764,11 @@ type AddClassRequest struct {
 	Part        uint8  `json:"part"`
 	Title       string `json:"title"`
 	Description string `json:"description"`
+	// 講義の実施日(YYYY-MM-DD)。任意
+	HeldOn string `json:"held_on"`
+	// 課題の提出期限(RFC3339)。任意
+	Deadline string `json:"deadline"`
+	LatePolicyRequest
 }
 
 type AddClassResponse struct {
@@ -1262,6 +1817,19 @@ func (h *handlers) AddClass(c echo.Context) error {
 		return c.String(http.StatusBadRequest, "This course is not in-progress.")
 	}
 
+	var heldOn sql.NullTime
+	if req.HeldOn != "" {
+		d, err := time.Parse(dateFormat, req.HeldOn)
+		if err != nil {
+			return c.String(http.StatusBadRequest, "Invalid date.")
+		}
+		heldOn = sql.NullTime{Time: d, Valid: true}
+	}
+	deadline, err := parseDeadline(req.Deadline)
+	if err != nil {
+		return c.String(http.StatusBadRequest, "Invalid deadline.")
+	}
+
 	tx, err := h.DB.Beginx()
 	if err != nil {
 		c.Logger().Error(err)
@@ -1277,12 +1845,18 @@ func (h *handlers) AddClass(c echo.Context) error {
 		Title:            req.Title,
 		Description:      req.Description,
 		SubmissionClosed: false,
+		HeldOn:           heldOn,
+		Deadline:         deadline,
+		LatePolicy:       LatePolicyNone,
+		LatePenaltyCap:   100,
+	}
+	if !req.LatePolicyRequest.apply(class) {
+		return c.String(http.StatusBadRequest, "Invalid late policy.")
 	}
 
-	h.SubDB.Exec("INSERT INTO `classes` (`id`, `course_id`, `part`, `title`, `description`) VALUES (?, ?, ?, ?, ?)",
-		classID, courseID, req.Part, req.Title, req.Description)
-	if _, err := tx.Exec("INSERT INTO `classes` (`id`, `course_id`, `part`, `title`, `description`) VALUES (?, ?, ?, ?, ?)",
-		classID, courseID, req.Part, req.Title, req.Description); err != nil {
+	query := "INSERT INTO `classes` (`id`, `course_id`, `part`, `title`, `description`, `held_on`, `deadline`, `grace_period`, `late_policy`, `late_penalty_rate`, `late_penalty_cap`) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)"
+	h.SubDB.Exec(query, classID, courseID, req.Part, req.Title, req.Description, heldOn, deadline, class.GracePeriod, class.LatePolicy, class.LatePenaltyRate, class.LatePenaltyCap)
+	if _, err := tx.Exec(query, classID, courseID, req.Part, req.Title, req.Description, heldOn, deadline, class.GracePeriod, class.LatePolicy, class.LatePenaltyRate, class.LatePenaltyCap); err != nil {
 		_ = tx.Rollback()
 		if mysqlErr, ok := err.(*mysql.MySQLError); ok && mysqlErr.Number == uint16(mysqlErrNumDuplicateEntry) {
 			var class Class
@@ -1308,7 +1882,7 @@ func (h *handlers) AddClass(c echo.Context) error {
 	ClassCacheMap[classID] = class
 	ClassCacheMux.Unlock()
 
-	h.discardClassesEtagByCource(courseID)
+	bumpVersion(classesKey(courseID), gradesKey)
 	return c.JSON(http.StatusCreated, AddClassResponse{ClassID: classID})
 }
 
@@ -1345,7 +1919,13 @@ func (h *handlers) SubmitAssignment(c echo.Context) error {
 	if !ok {
 		return c.String(http.StatusNotFound, "No such class.")
 	}
-	if class.SubmissionClosed {
+	// 提出期限が延長されていれば延長後の期限で判定する
+	effective, err := h.getEffectiveClass(class, userID)
+	if err != nil {
+		c.Logger().Error(err)
+		return c.NoContent(http.StatusInternalServerError)
+	}
+	if effective.isSubmissionClosed(time.Now()) {
 		return c.String(http.StatusBadRequest, "Submission has been closed for this class.")
 	}
 
@@ -1355,15 +1935,38 @@ func (h *handlers) SubmitAssignment(c echo.Context) error {
 	}
 	defer file.Close()
 
-	if _, err := h.DB.Exec("INSERT INTO `submissions` (`user_id`, `class_id`, `file_name`) VALUES (?, ?, ?) ON DUPLICATE KEY UPDATE `file_name` = VALUES(`file_name`)", userID, classID, header.Filename); err != nil {
+	// 再提出の場合は提出日時も更新するので、期限後の再提出は遅延提出になる
+	submittedAt := time.Now().Truncate(time.Microsecond)
+	tx, err := h.DB.Beginx()
+	if err != nil {
+		c.Logger().Error(err)
+		return c.NoContent(http.StatusInternalServerError)
+	}
+	defer tx.Rollback()
+	// 講義の削除と競合しないよう、講義の行を共有ロックで読みながら登録する。削除された講義には何も登録されない
+	result, err := tx.Exec("INSERT INTO `submissions` (`user_id`, `class_id`, `file_name`, `submitted_at`)"+
+		" SELECT ?, `id`, ?, ? FROM `classes` WHERE `id` = ? FOR SHARE"+
+		" ON DUPLICATE KEY UPDATE `file_name` = VALUES(`file_name`), `submitted_at` = VALUES(`submitted_at`)", userID, header.Filename, submittedAt, classID)
+	if err != nil {
+		c.Logger().Error(err)
+		return c.NoContent(http.StatusInternalServerError)
+	}
+	if n, err := result.RowsAffected(); err != nil {
+		c.Logger().Error(err)
+		return c.NoContent(http.StatusInternalServerError)
+	} else if n == 0 {
+		return c.String(http.StatusNotFound, "No such class.")
+	}
+	if err := tx.Commit(); err != nil {
 		c.Logger().Error(err)
 		return c.NoContent(http.StatusInternalServerError)
 	}
-	if _, err := h.SubDB.Exec("INSERT INTO `submissions` (`user_id`, `class_id`, `file_name`) VALUES (?, ?, ?) ON DUPLICATE KEY UPDATE `file_name` = VALUES(`file_name`)", userID, classID, header.Filename); err != nil {
+	if _, err := h.SubDB.Exec("INSERT INTO `submissions` (`user_id`, `class_id`, `file_name`, `submitted_at`) VALUES (?, ?, ?, ?) ON DUPLICATE KEY UPDATE `file_name` = VALUES(`file_name`), `submitted_at` = VALUES(`submitted_at`)", userID, classID, header.Filename, submittedAt); err != nil {
 		c.Logger().Error(err)
 		return c.NoContent(http.StatusInternalServerError)
 	}
 	h.submit(classID, userID)
+	bumpVersion(submissionsKey(courseID, userID), gradesKey)
 
 	dst := AssignmentsDirectory + classID + "-" + userID + ".pdf"
 	fd, err := os.OpenFile(dst, os.O_RDWR|os.O_CREATE, 0666)
@@ -1422,42 +2025,21 @@ func (h *handlers) RegisterScores(c echo.Context) error {
 		return c.NoContent(http.StatusInternalServerError)
 	}
 
-	type totalScoreS struct {
-		UserID     string `db:"user_id"`
-		TotalScore int    `db:"total_score"`
-	}
-	var totals []totalScoreS
-	query := "SELECT `users`.`id` AS `user_id`, IFNULL(SUM(`submissions`.`score`), 0) AS `total_score`" +
-		" FROM `users`" +
-		" JOIN `registrations` ON `users`.`id` = `registrations`.`user_id`" +
-		" JOIN `courses` ON `registrations`.`course_id` = `courses`.`id`" +
-		" LEFT JOIN `classes` ON `courses`.`id` = `classes`.`course_id`" +
-		" LEFT JOIN `submissions` ON `users`.`id` = `submissions`.`user_id` AND `submissions`.`class_id` = `classes`.`id`" +
-		" WHERE `courses`.`id` = ?" +
-		" GROUP BY `courses`.`id`, `users`.`id`"
-	if err := h.Balance().Select(&totals, query, class.CourseID); err != nil {
+	if err := h.updateTotalScores(h.DB, class.CourseID); err != nil {
 		c.Logger().Error(err)
 		return c.NoContent(http.StatusInternalServerError)
 	}
 
-	for _, total := range totals {
-		if _, err := h.DB.Exec("INSERT INTO `user_course_total_scores` (`total_score`, `course_id`, `user_id`) VALUES (?, ?, ?) ON DUPLICATE KEY UPDATE total_score = ?", total.TotalScore, class.CourseID, total.UserID, total.TotalScore); err != nil {
-			c.Logger().Error(err)
-			return c.NoContent(http.StatusInternalServerError)
-		}
-		if _, err := h.SubDB.Exec("INSERT INTO `user_course_total_scores` (`total_score`, `course_id`, `user_id`) VALUES (?, ?, ?) ON DUPLICATE KEY UPDATE total_score = ?", total.TotalScore, class.CourseID, total.UserID, total.TotalScore); err != nil {
-			c.Logger().Error(err)
-			return c.NoContent(http.StatusInternalServerError)
-		}
-	}
-
+	bumpVersion(gradesKey)
 	return c.NoContent(http.StatusNoContent)
 }
 
 type Submission struct {
-	UserID   string `db:"user_id"`
-	UserCode string `db:"user_code"`
-	FileName string `db:"file_name"`
+	UserID      string    `db:"user_id"`
+	UserCode    string    `db:"user_code"`
+	FileName    string    `db:"file_name"`
+	SubmittedAt time.Time `db:"submitted_at"`
+	Late        bool      `db:"-"`
 }
 
 // DownloadSubmittedAssignments GET /api/courses/:courseID/classes/:classID/assignments/export 提出済みの課題ファイルをzip形式で一括ダウンロード
@@ -1477,7 +2059,7 @@ func (h *handlers) DownloadSubmittedAssignments(c echo.Context) error {
 	}
 	defer tx.Rollback()
 	var submissions []Submission
-	query := "SELECT `submissions`.`user_id`, `submissions`.`file_name`, `users`.`code` AS `user_code`" +
+	query := "SELECT `submissions`.`user_id`, `submissions`.`file_name`, `submissions`.`submitted_at`, `users`.`code` AS `user_code`" +
 		" FROM `submissions`" +
 		" JOIN `users` ON `users`.`id` = `submissions`.`user_id`" +
 		" WHERE `class_id` = ?"
@@ -1485,6 +2067,14 @@ func (h *handlers) DownloadSubmittedAssignments(c echo.Context) error {
 		c.Logger().Error(err)
 		return c.NoContent(http.StatusInternalServerError)
 	}
+	extensions, err := getDeadlineExtensions(tx, courseID, "")
+	if err != nil {
+		c.Logger().Error(err)
+		return c.NoContent(http.StatusInternalServerError)
+	}
+	for i := range submissions {
+		submissions[i].Late, _ = class.withExtension(extensions, submissions[i].UserID).latePenalty(submissions[i].SubmittedAt)
+	}
 
 	zipFilePath := AssignmentsDirectory + classID + ".zip"
 	if err := createSubmissionsZip(zipFilePath, classID, submissions); err != nil {
@@ -1492,6 +2082,13 @@ func (h *handlers) DownloadSubmittedAssignments(c echo.Context) error {
 		return c.NoContent(http.StatusInternalServerError)
 	}
 
+	// 提出期限のある講義の締め切りはスケジューラと締め切りAPIに任せる
+	// 期限のない講義は従来通りダウンロードした時点で締め切る。教員は提出物をダウンロードしてから採点するので、
+	// 期限を設定しない既存の利用方法(ベンチマーカーを含む)では採点中に提出物が変わらないようこの動作に依存している
+	if class.Deadline.Valid || class.SubmissionClosed {
+		return c.File(zipFilePath)
+	}
+
 	if _, err := h.SubDB.Exec("UPDATE `classes` SET `submission_closed` = true WHERE `id` = ?", classID); err != nil {
 		c.Logger().Error(err)
 		return c.NoContent(http.StatusInternalServerError)
@@ -1507,7 +2104,7 @@ func (h *handlers) DownloadSubmittedAssignments(c echo.Context) error {
 	}
 	class.SubmissionClosed = true
 
-	h.discardClassesEtagByCource(courseID)
+	bumpVersion(classesKey(courseID))
 	return c.File(zipFilePath)
 }
 
@@ -1551,6 +2148,10 @@ func createSubmissionsZip(zipFilePath string, classID string, submissions []Subm
 		}
 
 		fileInfoHeader.Name = submission.UserCode + "-" + submission.FileName
+		// 遅延提出はファイル名で区別できるようにする
+		if submission.Late {
+			fileInfoHeader.Name = "LATE-" + fileInfoHeader.Name
+		}
 
 		addedFile, err := bodyWriter.CreateHeader(fileInfoHeader)
 		if err != nil {
@@ -1616,22 +2217,26 @@ func (h *handlers) GetAnnouncementList(c echo.Context) error {
 	}
 
 	query += " AND `unread_announcements`.`user_id` = ?" +
-		" AND `registrations`.`user_id` = ?" +
-		" ORDER BY `announcements`.`id` DESC" +
-		" LIMIT ? OFFSET ?"
+		" AND `registrations`.`user_id` = ?"
 	args = append(args, userID, userID)
 
-	var page int
-	if c.QueryParam("page") == "" {
-		page = 1
-	} else {
-		page, err = strconv.Atoi(c.QueryParam("page"))
-		if err != nil || page <= 0 {
-			return c.String(http.StatusBadRequest, "Invalid page.")
-		}
+	page, cursor, err := parsePagination(c)
+	if err != nil {
+		return c.String(http.StatusBadRequest, err.Error())
+	}
+
+	// お知らせIDの降順にkeyset方式でページングする
+	keysetCondition, order, keysetArgs, reversed := cursor.keyset("`announcements`.`id`", true)
+	query += keysetCondition +
+		" ORDER BY " + order +
+		" LIMIT ? OFFSET ?"
+	args = append(args, keysetArgs...)
+
+	limit := pageLimit
+	offset := cursor.Offset
+	if cursor.isKeyset() {
+		offset = 0
 	}
-	limit := 20
-	offset := limit * (page - 1)
 	// limitより多く上限を設定し、実際にlimitより多くレコードが取得できた場合は次のページが存在する
 	args = append(args, limit+1, offset)
 
@@ -1646,30 +2251,24 @@ func (h *handlers) GetAnnouncementList(c echo.Context) error {
 		return c.NoContent(http.StatusInternalServerError)
 	}
 
-	var links []string
-	linkURL, err := url.Parse(c.Request().URL.Path + "?" + c.Request().URL.RawQuery)
-	if err != nil {
-		c.Logger().Error(err)
-		return c.NoContent(http.StatusInternalServerError)
-	}
-
-	q := linkURL.Query()
-	if page > 1 {
-		q.Set("page", strconv.Itoa(page-1))
-		linkURL.RawQuery = q.Encode()
-		links = append(links, fmt.Sprintf("<%v>; rel=\"prev\"", linkURL))
+	hasMore := len(announcements) > limit
+	if hasMore {
+		announcements = announcements[:limit]
 	}
-	if len(announcements) > limit {
-		q.Set("page", strconv.Itoa(page+1))
-		linkURL.RawQuery = q.Encode()
-		links = append(links, fmt.Sprintf("<%v>; rel=\"next\"", linkURL))
-	}
-	if len(links) > 0 {
-		c.Response().Header().Set("Link", strings.Join(links, ","))
+	if reversed {
+		for i, j := 0, len(announcements)-1; i < j; i, j = i+1, j-1 {
+			announcements[i], announcements[j] = announcements[j], announcements[i]
+		}
 	}
 
-	if len(announcements) == limit+1 {
-		announcements = announcements[:len(announcements)-1]
+	var firstKey, lastKey string
+	if len(announcements) > 0 {
+		firstKey, lastKey = announcements[0].ID, announcements[len(announcements)-1].ID
+	}
+	prev, next := adjacentCursors(cursor, true, hasMore, firstKey, lastKey)
+	if err := setPaginationLinks(c, page, prev, next); err != nil {
+		c.Logger().Error(err)
+		return c.NoContent(http.StatusInternalServerError)
 	}
 
 	// 対象になっているお知らせが0件の時は空配列を返却
@@ -1769,6 +2368,12 @@ func (h *handlers) AddAnnouncement(c echo.Context) error {
 		return c.NoContent(http.StatusInternalServerError)
 	}
 
+	keys := make([]string, 0, len(targets))
+	for _, target := range targets {
+		keys = append(keys, announcementsKey(target.ID))
+	}
+	bumpVersion(keys...)
+
 	return c.NoContent(http.StatusCreated)
 }
 
@@ -1821,6 +2426,9 @@ func (h *handlers) GetAnnouncementDetail(c echo.Context) error {
 
 	count, _ := r.RowsAffected()
 	announcementDetail.Unread = count > 0
+	if announcementDetail.Unread {
+		bumpVersion(announcementsKey(userID))
+	}
 
 	if !announcementDetail.Unread {
 		c.Response().Header().Set("Cache-Control", "max-age=86400")
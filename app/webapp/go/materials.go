@@ -0,0 +1,234 @@
+package main
+
+import (
+	"database/sql"
+	"io"
+	"mime"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/labstack/echo/v4"
+)
+
+// 講義資料(スライド・配布物など)
+// ファイルは課題と同じくAssignmentsDirectoryに 講義ID-material-資料ID の名前で保存する
+
+const materialMaxSize = 100 << 20
+
+type ClassMaterial struct {
+	ID          string    `json:"id" db:"id"`
+	ClassID     string    `json:"-" db:"class_id"`
+	FileName    string    `json:"file_name" db:"file_name"`
+	ContentType string    `json:"content_type" db:"content_type"`
+	Size        int64     `json:"size" db:"size"`
+	UploadedBy  string    `json:"-" db:"uploaded_by"`
+	CreatedAt   time.Time `json:"created_at" db:"created_at"`
+}
+
+// ダウンロード時にそのままのContent-Typeで返す形式。それ以外はapplication/octet-streamで返す
+var materialContentTypes = map[string]bool{
+	"application/pdf": true,
+	"application/zip": true,
+	"application/vnd.openxmlformats-officedocument.presentationml.presentation": true,
+	"application/vnd.openxmlformats-officedocument.spreadsheetml.sheet":         true,
+	"application/vnd.openxmlformats-officedocument.wordprocessingml.document":   true,
+	"image/gif":  true,
+	"image/jpeg": true,
+	"image/png":  true,
+	"text/csv":   true,
+	"text/plain": true,
+}
+
+func materialPath(classID string, materialID string) string {
+	return AssignmentsDirectory + classID + "-material-" + materialID
+}
+
+// checkClassMember 講義が指定された科目のものであり、利用者が科目の履修者か担当教員であることを確認する
+// 問題がなければ講義とtrueを返す。問題があればエラーのレスポンスを書き込んでfalseを返す
+func (h *handlers) checkClassMember(c echo.Context, courseID string, classID string) (*Class, bool) {
+	userID, _, isAdmin, err := getUserInfo(c)
+	if err != nil {
+		c.Logger().Error(err)
+		c.NoContent(http.StatusInternalServerError)
+		return nil, false
+	}
+
+	if ok, _ := h.getCourse(courseID); !ok {
+		c.String(http.StatusNotFound, "No such course.")
+		return nil, false
+	}
+	ok, class := h.getClass(classID)
+	if !ok || class.CourseID != courseID {
+		c.String(http.StatusNotFound, "No such class.")
+		return nil, false
+	}
+	var member bool
+	if isAdmin {
+		member, err = h.isCourseStaff(courseID, userID)
+	} else {
+		member, err = h.isUserRegistered(userID, courseID)
+	}
+	if err != nil {
+		c.Logger().Error(err)
+		c.NoContent(http.StatusInternalServerError)
+		return nil, false
+	}
+	if !member {
+		c.String(http.StatusForbidden, "You have not taken this course.")
+		return nil, false
+	}
+	return class, true
+}
+
+// GetClassMaterials GET /api/courses/:courseID/classes/:classID/materials 講義資料の一覧
+func (h *handlers) GetClassMaterials(c echo.Context) error {
+	courseID := c.Param("courseID")
+	classID := c.Param("classID")
+
+	_, ok := h.checkClassMember(c, courseID, classID)
+	if !ok {
+		return nil
+	}
+
+	// 結果が0件の時は空配列を返却
+	materials := make([]ClassMaterial, 0)
+	if err := h.Balance().Select(&materials, "SELECT * FROM `class_materials` WHERE `class_id` = ? ORDER BY `created_at`, `id`", classID); err != nil {
+		c.Logger().Error(err)
+		return c.NoContent(http.StatusInternalServerError)
+	}
+
+	return c.JSON(http.StatusOK, materials)
+}
+
+// UploadClassMaterial POST /api/courses/:courseID/classes/:classID/materials 講義資料のアップロード
+func (h *handlers) UploadClassMaterial(c echo.Context) error {
+	userID, _, _, err := getUserInfo(c)
+	if err != nil {
+		c.Logger().Error(err)
+		return c.NoContent(http.StatusInternalServerError)
+	}
+
+	courseID := c.Param("courseID")
+	classID := c.Param("classID")
+
+	_, ok := h.checkClassStaff(c, courseID, classID)
+	if !ok {
+		return nil
+	}
+
+	file, header, err := c.Request().FormFile("file")
+	if err != nil {
+		return c.String(http.StatusBadRequest, "Invalid file.")
+	}
+	defer file.Close()
+	if header.Size > materialMaxSize {
+		return c.String(http.StatusRequestEntityTooLarge, "The file is too large.")
+	}
+
+	contentType := header.Header.Get("Content-Type")
+	if contentType == "" {
+		contentType = "application/octet-stream"
+	}
+	material := ClassMaterial{
+		ID:          newULID(),
+		ClassID:     classID,
+		FileName:    header.Filename,
+		ContentType: contentType,
+		Size:        header.Size,
+		UploadedBy:  userID,
+		CreatedAt:   time.Now().Truncate(time.Microsecond),
+	}
+
+	// ファイルを書き込んでから登録する。登録に失敗したらファイルを消す
+	dst := materialPath(classID, material.ID)
+	fd, err := os.Create(dst)
+	if err != nil {
+		c.Logger().Error(err)
+		return c.NoContent(http.StatusInternalServerError)
+	}
+	if _, err := io.Copy(fd, file); err != nil {
+		fd.Close()
+		os.Remove(dst)
+		c.Logger().Error(err)
+		return c.NoContent(http.StatusInternalServerError)
+	}
+	if err := fd.Close(); err != nil {
+		os.Remove(dst)
+		c.Logger().Error(err)
+		return c.NoContent(http.StatusInternalServerError)
+	}
+
+	query := "INSERT INTO `class_materials` (`id`, `class_id`, `file_name`, `content_type`, `size`, `uploaded_by`, `created_at`) VALUES (?, ?, ?, ?, ?, ?, ?)"
+	h.SubDB.Exec(query, material.ID, material.ClassID, material.FileName, material.ContentType, material.Size, material.UploadedBy, material.CreatedAt)
+	if _, err := h.DB.Exec(query, material.ID, material.ClassID, material.FileName, material.ContentType, material.Size, material.UploadedBy, material.CreatedAt); err != nil {
+		os.Remove(dst)
+		c.Logger().Error(err)
+		return c.NoContent(http.StatusInternalServerError)
+	}
+
+	return c.JSON(http.StatusCreated, material)
+}
+
+// DownloadClassMaterial GET /api/courses/:courseID/classes/:classID/materials/:materialID 講義資料のダウンロード
+func (h *handlers) DownloadClassMaterial(c echo.Context) error {
+	courseID := c.Param("courseID")
+	classID := c.Param("classID")
+	materialID := c.Param("materialID")
+
+	_, ok := h.checkClassMember(c, courseID, classID)
+	if !ok {
+		return nil
+	}
+
+	var material ClassMaterial
+	if err := h.Balance().Get(&material, "SELECT * FROM `class_materials` WHERE `id` = ? AND `class_id` = ?", materialID, classID); err != nil {
+		if err == sql.ErrNoRows {
+			return c.String(http.StatusNotFound, "No such material.")
+		}
+		c.Logger().Error(err)
+		return c.NoContent(http.StatusInternalServerError)
+	}
+
+	// アップロード時に指定された形式は信用できないので、ブラウザに推測させず、既知の形式以外はバイナリとして返す
+	contentType := "application/octet-stream"
+	if mediaType, _, err := mime.ParseMediaType(material.ContentType); err == nil && materialContentTypes[mediaType] {
+		contentType = material.ContentType
+	}
+	c.Response().Header().Set(echo.HeaderXContentTypeOptions, "nosniff")
+	c.Response().Header().Set(echo.HeaderContentType, contentType)
+	return c.Attachment(materialPath(classID, material.ID), material.FileName)
+}
+
+// DeleteClassMaterial DELETE /api/courses/:courseID/classes/:classID/materials/:materialID 講義資料の削除
+func (h *handlers) DeleteClassMaterial(c echo.Context) error {
+	courseID := c.Param("courseID")
+	classID := c.Param("classID")
+	materialID := c.Param("materialID")
+
+	_, ok := h.checkClassStaff(c, courseID, classID)
+	if !ok {
+		return nil
+	}
+
+	query := "DELETE FROM `class_materials` WHERE `id` = ? AND `class_id` = ?"
+	h.SubDB.Exec(query, materialID, classID)
+	result, err := h.DB.Exec(query, materialID, classID)
+	if err != nil {
+		c.Logger().Error(err)
+		return c.NoContent(http.StatusInternalServerError)
+	}
+	if n, err := result.RowsAffected(); err != nil {
+		c.Logger().Error(err)
+		return c.NoContent(http.StatusInternalServerError)
+	} else if n == 0 {
+		return c.String(http.StatusNotFound, "No such material.")
+	}
+
+	// DBからは参照されないので、ファイルの削除に失敗してもログのみ
+	if err := os.Remove(materialPath(classID, materialID)); err != nil && !os.IsNotExist(err) {
+		c.Logger().Error(err)
+	}
+
+	return c.NoContent(http.StatusNoContent)
+}
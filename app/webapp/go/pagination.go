@@ -0,0 +1,154 @@
+package main
+
+import (
+	"encoding/base64"
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/goccy/go-json"
+	"github.com/labstack/echo/v4"
+)
+
+// pageCursor ページングのカーソル。クライアントには不透明な文字列として渡す
+// After/Beforeはキー(並び順の列の値)によるkeyset方式で、それ以前/以後の要素を返す
+// キーで並べられない場合(関連度順の検索など)はOffsetを使う
+type pageCursor struct {
+	After  string `json:"a,omitempty"`
+	Before string `json:"b,omitempty"`
+	Offset int    `json:"o,omitempty"`
+}
+
+func (p *pageCursor) encode() string {
+	b, _ := json.Marshal(p)
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+func decodeCursor(s string) (*pageCursor, error) {
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, err
+	}
+	var p pageCursor
+	if err := json.Unmarshal(b, &p); err != nil {
+		return nil, err
+	}
+	if p.After != "" && p.Before != "" || p.Offset < 0 {
+		return nil, fmt.Errorf("invalid cursor")
+	}
+	return &p, nil
+}
+
+func (p *pageCursor) isKeyset() bool {
+	return p.After != "" || p.Before != ""
+}
+
+// keyset カーソルの位置より後(Beforeの場合は前)の要素を取得する条件と並び順
+// Beforeの場合は逆順で取得するので、取得後にreversedに従って並べ直すこと
+func (p *pageCursor) keyset(column string, desc bool) (condition string, order string, args []interface{}, reversed bool) {
+	asc, after, before := " ASC", " > ?", " < ?"
+	if desc {
+		asc, after, before = " DESC", " < ?", " > ?"
+	}
+	switch {
+	case p.After != "":
+		return " AND " + column + after, column + asc, []interface{}{p.After}, false
+	case p.Before != "":
+		rev := " DESC"
+		if desc {
+			rev = " ASC"
+		}
+		return " AND " + column + before, column + rev, []interface{}{p.Before}, true
+	default:
+		return "", column + asc, nil, false
+	}
+}
+
+// parsePagination page と cursor クエリパラメータを読む。cursorが指定された場合はそちらを優先する
+// pageはcursorがある場合もLinkヘッダのページ番号を引き継ぐために使う
+func parsePagination(c echo.Context) (int, *pageCursor, error) {
+	page := 1
+	if c.QueryParam("page") != "" {
+		var err error
+		page, err = strconv.Atoi(c.QueryParam("page"))
+		if err != nil || page <= 0 {
+			return 0, nil, fmt.Errorf("Invalid page.")
+		}
+	}
+	if s := c.QueryParam("cursor"); s != "" {
+		cursor, err := decodeCursor(s)
+		if err != nil {
+			return 0, nil, fmt.Errorf("Invalid cursor.")
+		}
+		return page, cursor, nil
+	}
+	return page, &pageCursor{Offset: pageLimit * (page - 1)}, nil
+}
+
+const pageLimit = 20
+
+// adjacentCursors 前後のページのカーソル。ページが存在しない場合はnil
+// hasMoreは並び順の方向(Beforeの場合は前方向)にlimitより多くの要素が取得できたかどうか
+func adjacentCursors(cursor *pageCursor, keyset bool, hasMore bool, firstKey string, lastKey string) (prev *pageCursor, next *pageCursor) {
+	if !keyset {
+		if cursor.Offset > 0 {
+			offset := cursor.Offset - pageLimit
+			if offset < 0 {
+				offset = 0
+			}
+			prev = &pageCursor{Offset: offset}
+		}
+		if hasMore {
+			next = &pageCursor{Offset: cursor.Offset + pageLimit}
+		}
+		return prev, next
+	}
+
+	if firstKey == "" {
+		return nil, nil
+	}
+	hasPrev, hasNext := cursor.After != "" || cursor.Offset > 0, hasMore
+	if cursor.Before != "" {
+		hasPrev, hasNext = hasMore, true
+	}
+	if hasPrev {
+		prev = &pageCursor{Before: firstKey}
+	}
+	if hasNext {
+		next = &pageCursor{After: lastKey}
+	}
+	return prev, next
+}
+
+// setPaginationLinks Linkヘッダを設定する
+// 既存のクライアントのためにカーソルと合わせてページ番号も付ける
+func setPaginationLinks(c echo.Context, page int, prev *pageCursor, next *pageCursor) error {
+	linkURL, err := url.Parse(c.Request().URL.Path + "?" + c.Request().URL.RawQuery)
+	if err != nil {
+		return err
+	}
+
+	var links []string
+	q := linkURL.Query()
+	if prev != nil {
+		prevPage := page - 1
+		if prevPage < 1 {
+			prevPage = 1
+		}
+		q.Set("page", strconv.Itoa(prevPage))
+		q.Set("cursor", prev.encode())
+		linkURL.RawQuery = q.Encode()
+		links = append(links, fmt.Sprintf("<%v>; rel=\"prev\"", linkURL))
+	}
+	if next != nil {
+		q.Set("page", strconv.Itoa(page+1))
+		q.Set("cursor", next.encode())
+		linkURL.RawQuery = q.Encode()
+		links = append(links, fmt.Sprintf("<%v>; rel=\"next\"", linkURL))
+	}
+	if len(links) > 0 {
+		c.Response().Header().Set("Link", strings.Join(links, ","))
+	}
+	return nil
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestCursorEncodeDecode(t *testing.T) {
+	tests := []pageCursor{
+		{},
+		{After: "01FGJ"},
+		{Before: "01FGJ"},
+		{Offset: 40},
+	}
+	for _, cursor := range tests {
+		got, err := decodeCursor(cursor.encode())
+		if err != nil {
+			t.Errorf("decodeCursor(%+v) error: %v", cursor, err)
+			continue
+		}
+		if *got != cursor {
+			t.Errorf("decodeCursor() = %+v, want %+v", *got, cursor)
+		}
+	}
+
+	invalid := []string{
+		"!!",
+		(&pageCursor{After: "a", Before: "b"}).encode(),
+		(&pageCursor{Offset: -1}).encode(),
+	}
+	for _, s := range invalid {
+		if _, err := decodeCursor(s); err == nil {
+			t.Errorf("decodeCursor(%q) should fail", s)
+		}
+	}
+}
+
+func TestKeyset(t *testing.T) {
+	tests := []struct {
+		name      string
+		cursor    pageCursor
+		desc      bool
+		condition string
+		order     string
+		args      []interface{}
+		reversed  bool
+	}{
+		{"first page", pageCursor{}, false, "", "c ASC", nil, false},
+		{"first page desc", pageCursor{}, true, "", "c DESC", nil, false},
+		{"after", pageCursor{After: "k"}, false, " AND c > ?", "c ASC", []interface{}{"k"}, false},
+		{"after desc", pageCursor{After: "k"}, true, " AND c < ?", "c DESC", []interface{}{"k"}, false},
+		{"before is fetched in reverse", pageCursor{Before: "k"}, false, " AND c < ?", "c DESC", []interface{}{"k"}, true},
+		{"before desc is fetched in reverse", pageCursor{Before: "k"}, true, " AND c > ?", "c ASC", []interface{}{"k"}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			condition, order, args, reversed := tt.cursor.keyset("c", tt.desc)
+			if condition != tt.condition || order != tt.order || !reflect.DeepEqual(args, tt.args) || reversed != tt.reversed {
+				t.Errorf("keyset() = (%q, %q, %v, %v), want (%q, %q, %v, %v)",
+					condition, order, args, reversed, tt.condition, tt.order, tt.args, tt.reversed)
+			}
+		})
+	}
+}
+
+func TestAdjacentCursors(t *testing.T) {
+	tests := []struct {
+		name     string
+		cursor   pageCursor
+		keyset   bool
+		hasMore  bool
+		firstKey string
+		lastKey  string
+		prev     *pageCursor
+		next     *pageCursor
+	}{
+		{"offset first page", pageCursor{}, false, true, "", "", nil, &pageCursor{Offset: pageLimit}},
+		{"offset last page", pageCursor{Offset: pageLimit}, false, false, "", "", &pageCursor{}, nil},
+		{"offset prev is clamped", pageCursor{Offset: 5}, false, false, "", "", &pageCursor{}, nil},
+		{"keyset empty page", pageCursor{After: "k"}, true, false, "", "", nil, nil},
+		{"keyset first page", pageCursor{}, true, true, "a", "t", nil, &pageCursor{After: "t"}},
+		{"keyset only page", pageCursor{}, true, false, "a", "t", nil, nil},
+		{"keyset after", pageCursor{After: "k"}, true, true, "l", "t", &pageCursor{Before: "l"}, &pageCursor{After: "t"}},
+		{"keyset after last page", pageCursor{After: "k"}, true, false, "l", "t", &pageCursor{Before: "l"}, nil},
+		// Beforeの場合、hasMoreは前方向にまだ要素があるかどうか
+		{"keyset before", pageCursor{Before: "k"}, true, true, "a", "j", &pageCursor{Before: "a"}, &pageCursor{After: "j"}},
+		{"keyset before reaches first page", pageCursor{Before: "k"}, true, false, "a", "j", nil, &pageCursor{After: "j"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			prev, next := adjacentCursors(&tt.cursor, tt.keyset, tt.hasMore, tt.firstKey, tt.lastKey)
+			if !reflect.DeepEqual(prev, tt.prev) || !reflect.DeepEqual(next, tt.next) {
+				t.Errorf("adjacentCursors() = (%+v, %+v), want (%+v, %+v)", prev, next, tt.prev, tt.next)
+			}
+		})
+	}
+}
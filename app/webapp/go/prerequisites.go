@@ -0,0 +1,108 @@
+package main
+
+import (
+	"sync"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// Prerequisite 履修の前提となる科目(科目コードで指定)と、その科目で必要な合計点
+type Prerequisite struct {
+	Code          string `json:"code" db:"prerequisite_code"`
+	MinTotalScore int    `json:"min_total_score" db:"min_total_score"`
+}
+
+var (
+	PrerequisiteCacheMap = make(map[string][]Prerequisite)
+	PrerequisiteCacheMux = sync.RWMutex{}
+)
+
+func (h *handlers) getPrerequisites(courseID string) ([]Prerequisite, error) {
+	PrerequisiteCacheMux.RLock()
+	if prerequisites, ok := PrerequisiteCacheMap[courseID]; ok {
+		PrerequisiteCacheMux.RUnlock()
+		return prerequisites, nil
+	}
+	PrerequisiteCacheMux.RUnlock()
+
+	prerequisites := []Prerequisite{}
+	if err := h.Balance().Select(&prerequisites, "SELECT `prerequisite_code`, `min_total_score` FROM `course_prerequisites` WHERE `course_id` = ? ORDER BY `prerequisite_code`", courseID); err != nil {
+		return nil, err
+	}
+	PrerequisiteCacheMux.Lock()
+	PrerequisiteCacheMap[courseID] = prerequisites
+	PrerequisiteCacheMux.Unlock()
+	return prerequisites, nil
+}
+
+func validatePrerequisites(courseCode string, prerequisites []Prerequisite) bool {
+	seen := make(map[string]bool, len(prerequisites))
+	for _, p := range prerequisites {
+		if p.Code == "" || p.Code == courseCode || p.MinTotalScore < 0 || seen[p.Code] {
+			return false
+		}
+		seen[p.Code] = true
+	}
+	return true
+}
+
+// setPrerequisites 科目の前提科目を置き換える。キャッシュはコミット後にcachePrerequisitesで更新する
+func (h *handlers) setPrerequisites(db sqlx.Execer, sub sqlx.Execer, courseID string, prerequisites []Prerequisite) error {
+	sub.Exec("DELETE FROM `course_prerequisites` WHERE `course_id` = ?", courseID)
+	if _, err := db.Exec("DELETE FROM `course_prerequisites` WHERE `course_id` = ?", courseID); err != nil {
+		return err
+	}
+	return h.insertPrerequisites(db, sub, courseID, prerequisites)
+}
+
+// insertPrerequisites 新しく登録した科目の前提科目を登録する
+func (h *handlers) insertPrerequisites(db sqlx.Execer, sub sqlx.Execer, courseID string, prerequisites []Prerequisite) error {
+	for _, p := range prerequisites {
+		sub.Exec("INSERT INTO `course_prerequisites` (`course_id`, `prerequisite_code`, `min_total_score`) VALUES (?, ?, ?)", courseID, p.Code, p.MinTotalScore)
+		if _, err := db.Exec("INSERT INTO `course_prerequisites` (`course_id`, `prerequisite_code`, `min_total_score`) VALUES (?, ?, ?)", courseID, p.Code, p.MinTotalScore); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func cachePrerequisites(courseID string, prerequisites []Prerequisite) {
+	PrerequisiteCacheMux.Lock()
+	PrerequisiteCacheMap[courseID] = append([]Prerequisite{}, prerequisites...)
+	PrerequisiteCacheMux.Unlock()
+}
+
+// getCompletedCourseScores 学生が修了した(closedの)科目の科目コード毎の合計点
+func getCompletedCourseScores(db sqlx.Queryer, userID string) (map[string]int, error) {
+	type completedS struct {
+		Code       string `db:"code"`
+		TotalScore int    `db:"total_score"`
+	}
+	var completed []completedS
+	query := "SELECT `courses`.`code`, IFNULL(`user_course_total_scores`.`total_score`, 0) AS `total_score`" +
+		" FROM `registrations`" +
+		" JOIN `courses` ON `courses`.`id` = `registrations`.`course_id`" +
+		" LEFT JOIN `user_course_total_scores` ON `user_course_total_scores`.`course_id` = `courses`.`id` AND `user_course_total_scores`.`user_id` = `registrations`.`user_id`" +
+		" WHERE `registrations`.`user_id` = ? AND `courses`.`status` = ?"
+	if err := sqlx.Select(db, &completed, query, userID, StatusClosed); err != nil {
+		return nil, err
+	}
+
+	scores := make(map[string]int, len(completed))
+	for _, c := range completed {
+		if score, ok := scores[c.Code]; !ok || score < c.TotalScore {
+			scores[c.Code] = c.TotalScore
+		}
+	}
+	return scores, nil
+}
+
+func prerequisitesMet(prerequisites []Prerequisite, completed map[string]int) bool {
+	for _, p := range prerequisites {
+		score, ok := completed[p.Code]
+		if !ok || score < p.MinTotalScore {
+			return false
+		}
+	}
+	return true
+}
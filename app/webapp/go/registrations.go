@@ -0,0 +1,317 @@
+package main
+
+import (
+	"net/http"
+	"sort"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/labstack/echo/v4"
+)
+
+// isDroppable 履修登録期間中の科目のみ履修を取り消せる
+func (h *handlers) isDroppable(course *Course) bool {
+	return course.Status == StatusRegistration && h.isCourseRegistrationOpen(course)
+}
+
+// dropRegistration 履修登録を取り消し、関連するデータを削除する
+// 空いた席にはキャンセル待ちの学生を繰り上げ、繰り上がった学生のIDを返す
+func (h *handlers) dropRegistration(tx *sqlx.Tx, userID string, course *Course) ([]string, error) {
+	queries := []string{
+		"DELETE FROM `registrations` WHERE `course_id` = ? AND `user_id` = ?",
+		"DELETE `unread_announcements` FROM `unread_announcements`" +
+			" JOIN `announcements` ON `announcements`.`id` = `unread_announcements`.`announcement_id`" +
+			" WHERE `announcements`.`course_id` = ? AND `unread_announcements`.`user_id` = ?",
+		"DELETE FROM `user_course_total_scores` WHERE `course_id` = ? AND `user_id` = ?",
+	}
+	for _, query := range queries {
+		if _, err := h.SubDB.Exec(query, course.ID, userID); err != nil {
+			return nil, err
+		}
+		if _, err := tx.Exec(query, course.ID, userID); err != nil {
+			return nil, err
+		}
+	}
+
+	return h.promoteWaitlist(tx, course)
+}
+
+// afterRegistrationDropped 履修取り消しをコミットした後に呼ぶ
+func afterRegistrationDropped(userID string, courseIDs []string, promoted []string) {
+	for _, courseID := range courseIDs {
+		discardUserCourse(userID, courseID)
+	}
+	afterRegistrationChanged(append(promoted, userID)...)
+}
+
+type registrationPlan struct {
+	Errors     RegisterCoursesErrorResponse
+	NewlyAdded []Course
+	Dropped    []Course
+	// 登録後の履修科目(closedのものを除く)
+	Courses []Course
+}
+
+// planRegistration 履修登録の検査を行い、追加・取り消しする科目を決める
+// 書き込みは行わないが、定員のある科目は行ロックを取るので同じトランザクションで登録すること
+// dryRunの場合は行ロックを取らずに空きを確認する
+func (h *handlers) planRegistration(tx *sqlx.Tx, userID string, req []RegisterCourseRequestContent, replace bool, dryRun bool) (*registrationPlan, error) {
+	var errors RegisterCoursesErrorResponse
+	var newlyAdded []Course
+	var completed map[string]int
+	for _, courseReq := range req {
+		courseID := courseReq.ID
+		ok, course := h.getCourse(courseID)
+		if !ok {
+			errors.CourseNotFound = append(errors.CourseNotFound, courseReq.ID)
+			continue
+		}
+
+		if !h.isCourseRegistrable(course) {
+			// 置き換えの場合は時間割全体を送るので、履修中の科目が含まれていてもエラーにしない
+			if replace {
+				registered, err := h.isUserRegistered(userID, course.ID)
+				if err != nil {
+					return nil, err
+				}
+				if registered {
+					continue
+				}
+			}
+			errors.NotRegistrableStatus = append(errors.NotRegistrableStatus, course.ID)
+			continue
+		}
+
+		// すでに履修登録済みの科目は無視する
+		var count int
+		if err := tx.Get(&count, "SELECT COUNT(*) FROM `registrations` WHERE `course_id` = ? AND `user_id` = ? LIMIT 1", course.ID, userID); err != nil {
+			return nil, err
+		}
+		if count > 0 {
+			continue
+		}
+
+		prerequisites, err := h.getPrerequisites(course.ID)
+		if err != nil {
+			return nil, err
+		}
+		if len(prerequisites) > 0 {
+			if completed == nil {
+				if completed, err = getCompletedCourseScores(tx, userID); err != nil {
+					return nil, err
+				}
+			}
+			if !prerequisitesMet(prerequisites, completed) {
+				errors.PrerequisiteNotMet = append(errors.PrerequisiteNotMet, course.ID)
+				continue
+			}
+		}
+
+		// 定員のある科目は行ロックを取ってから空きを確認する
+		var hasVacancy bool
+		if dryRun {
+			hasVacancy, err = hasCourseSeats(tx, course)
+		} else {
+			hasVacancy, err = lockCourseSeats(tx, course)
+		}
+		if err != nil {
+			return nil, err
+		}
+		if !hasVacancy {
+			errors.CourseFull = append(errors.CourseFull, course.ID)
+			continue
+		}
+
+		newlyAdded = append(newlyAdded, *course)
+	}
+
+	var alreadyRegistered []Course
+	query := "SELECT `courses`.*" +
+		" FROM `courses`" +
+		" JOIN `registrations` ON `courses`.`id` = `registrations`.`course_id`" +
+		" WHERE `courses`.`status` != ? AND `registrations`.`user_id` = ?"
+	if err := tx.Select(&alreadyRegistered, query, StatusClosed, userID); err != nil {
+		return nil, err
+	}
+
+	// 指定されなかった履修済みの科目は取り消す
+	// 履修登録期間の科目のみが対象で、開講中の科目は指定されなくてもそのまま残す
+	var dropped []Course
+	if replace {
+		requested := make(map[string]bool, len(req))
+		for _, courseReq := range req {
+			requested[courseReq.ID] = true
+		}
+		kept := alreadyRegistered[:0]
+		for _, course := range alreadyRegistered {
+			if requested[course.ID] || course.Status != StatusRegistration {
+				kept = append(kept, course)
+				continue
+			}
+			if !h.isDroppable(&course) {
+				errors.NotDroppableStatus = append(errors.NotDroppableStatus, course.ID)
+				kept = append(kept, course)
+				continue
+			}
+			dropped = append(dropped, course)
+		}
+		alreadyRegistered = kept
+	}
+
+	alreadyRegistered = append(alreadyRegistered, newlyAdded...)
+	if len(newlyAdded) > 0 || len(dropped) > 0 {
+		errors.CreditRuleViolation = registrationCreditRules.check(alreadyRegistered, replace)
+	}
+	for i := range newlyAdded {
+		for j := range alreadyRegistered {
+			conflict, err := h.schedulesConflict(&newlyAdded[i], &alreadyRegistered[j])
+			if err != nil {
+				return nil, err
+			}
+			if conflict {
+				errors.ScheduleConflict = append(errors.ScheduleConflict, newlyAdded[i].ID)
+				break
+			}
+		}
+	}
+
+	return &registrationPlan{
+		Errors:     errors,
+		NewlyAdded: newlyAdded,
+		Dropped:    dropped,
+		Courses:    alreadyRegistered,
+	}, nil
+}
+
+// DropCourse DELETE /api/users/me/courses/:courseID 履修登録の取り消し
+func (h *handlers) DropCourse(c echo.Context) error {
+	userID, _, _, err := getUserInfo(c)
+	if err != nil {
+		c.Logger().Error(err)
+		return c.NoContent(http.StatusInternalServerError)
+	}
+
+	courseID := c.Param("courseID")
+	ok, course := h.getCourse(courseID)
+	if !ok {
+		return c.String(http.StatusNotFound, "No such course.")
+	}
+	if !h.isDroppable(course) {
+		return c.String(http.StatusBadRequest, "This course is not in the registration period.")
+	}
+
+	tx, err := h.DB.Beginx()
+	if err != nil {
+		c.Logger().Error(err)
+		return c.NoContent(http.StatusInternalServerError)
+	}
+	defer tx.Rollback()
+
+	var count int
+	if err := tx.Get(&count, "SELECT COUNT(*) FROM `registrations` WHERE `course_id` = ? AND `user_id` = ? LIMIT 1", courseID, userID); err != nil {
+		c.Logger().Error(err)
+		return c.NoContent(http.StatusInternalServerError)
+	}
+	if count == 0 {
+		return c.String(http.StatusNotFound, "You have not taken this course.")
+	}
+
+	promoted, err := h.dropRegistration(tx, userID, course)
+	if err != nil {
+		c.Logger().Error(err)
+		return c.NoContent(http.StatusInternalServerError)
+	}
+
+	if err := tx.Commit(); err != nil {
+		c.Logger().Error(err)
+		return c.NoContent(http.StatusInternalServerError)
+	}
+	afterRegistrationDropped(userID, []string{courseID}, promoted)
+
+	return c.NoContent(http.StatusNoContent)
+}
+
+type ValidateRegistrationResponse struct {
+	Valid     bool                                 `json:"valid"`
+	Errors    RegisterCoursesErrorResponse         `json:"errors"`
+	Timetable []GetRegisteredCourseResponseContent `json:"timetable"`
+}
+
+// ValidateRegistration POST /api/users/me/courses/validate 履修登録の事前検査
+// RegisterCoursesと同じ検査を行い、書き込みはせずに結果と登録後の時間割を返す
+func (h *handlers) ValidateRegistration(c echo.Context) error {
+	userID, _, _, err := getUserInfo(c)
+	if err != nil {
+		c.Logger().Error(err)
+		return c.NoContent(http.StatusInternalServerError)
+	}
+
+	var req []RegisterCourseRequestContent
+	if err := c.Bind(&req); err != nil {
+		return c.String(http.StatusBadRequest, "Invalid format.")
+	}
+	sort.Slice(req, func(i, j int) bool {
+		return req[i].ID < req[j].ID
+	})
+	replace := c.QueryParam("mode") == "replace"
+
+	tx, err := h.DB.Beginx()
+	if err != nil {
+		c.Logger().Error(err)
+		return c.NoContent(http.StatusInternalServerError)
+	}
+	// 書き込みも行ロックも行わないので必ずロールバックする
+	defer tx.Rollback()
+
+	plan, err := h.planRegistration(tx, userID, req, replace, true)
+	if err != nil {
+		c.Logger().Error(err)
+		return c.NoContent(http.StatusInternalServerError)
+	}
+
+	courses := plan.Courses
+	sort.Slice(courses, func(i, j int) bool {
+		if courses[i].DayOfWeek != courses[j].DayOfWeek {
+			return dayOfWeekIndex(courses[i].DayOfWeek) < dayOfWeekIndex(courses[j].DayOfWeek)
+		}
+		return courses[i].Period < courses[j].Period
+	})
+
+	timetable := make([]GetRegisteredCourseResponseContent, 0, len(courses))
+	for _, course := range courses {
+		var teacher User
+		if err := tx.Get(&teacher, "SELECT * FROM `users` WHERE `id` = ?", course.TeacherID); err != nil {
+			c.Logger().Error(err)
+			return c.NoContent(http.StatusInternalServerError)
+		}
+		slots, err := h.getCourseSlots(course.ID, course.primarySlot())
+		if err != nil {
+			c.Logger().Error(err)
+			return c.NoContent(http.StatusInternalServerError)
+		}
+
+		timetable = append(timetable, GetRegisteredCourseResponseContent{
+			ID:        course.ID,
+			Name:      course.Name,
+			Teacher:   teacher.Name,
+			Period:    course.Period,
+			DayOfWeek: course.DayOfWeek,
+			TermID:    nullStringPtr(course.TermID),
+			Slots:     slots,
+		})
+	}
+
+	return c.JSON(http.StatusOK, ValidateRegistrationResponse{
+		Valid:     !plan.Errors.hasError(),
+		Errors:    plan.Errors,
+		Timetable: timetable,
+	})
+}
+
+func dayOfWeekIndex(day DayOfWeek) int {
+	for i, d := range daysOfWeek {
+		if d == day {
+			return i
+		}
+	}
+	return len(daysOfWeek)
+}
@@ -0,0 +1,200 @@
+package main
+
+import (
+	"strings"
+	"unicode/utf8"
+)
+
+// 科目検索のキーワード
+// - 空白区切りの語はすべて含む(AND)
+// - "..." で囲んだ部分は空白を含めて一つの語(フレーズ)として扱う
+// - OR または | で区切った語はいずれかを含めば良い
+// name, keywords, description のFULLTEXTインデックス(ngramパーサ)で検索し、関連度順に並べる
+
+type searchTerm struct {
+	Text   string
+	Phrase bool
+}
+
+// 各要素の中はOR、要素同士はAND
+type searchQuery [][]searchTerm
+
+// ngramパーサのトークン長(ngram_token_size)より短い語はFULLTEXTインデックスで検索できないのでLIKEで検索する
+const ngramTokenSize = 2
+
+const fulltextColumns = "`courses`.`name`, `courses`.`keywords`, `courses`.`description`"
+
+func parseSearchQuery(s string) searchQuery {
+	s = strings.ReplaceAll(s, "　", " ")
+
+	var tokens []searchTerm
+	for len(s) > 0 {
+		s = strings.TrimLeft(s, " \t")
+		if s == "" {
+			break
+		}
+		if s[0] == '"' {
+			end := strings.IndexByte(s[1:], '"')
+			var text string
+			if end < 0 {
+				text, s = s[1:], ""
+			} else {
+				text, s = s[1:end+1], s[end+2:]
+			}
+			if text = strings.Join(strings.Fields(text), " "); text != "" {
+				tokens = append(tokens, searchTerm{Text: text, Phrase: true})
+			}
+			continue
+		}
+		end := strings.IndexAny(s, " \t\"")
+		if end < 0 {
+			end = len(s)
+		}
+		tokens = append(tokens, searchTerm{Text: s[:end]})
+		s = s[end:]
+	}
+
+	var query searchQuery
+	joinOr := false
+	for _, token := range tokens {
+		if !token.Phrase && (token.Text == "OR" || token.Text == "|") {
+			joinOr = len(query) > 0
+			continue
+		}
+		if joinOr {
+			query[len(query)-1] = append(query[len(query)-1], token)
+		} else {
+			query = append(query, []searchTerm{token})
+		}
+		joinOr = false
+	}
+	return query
+}
+
+// booleanModeOperators BOOLEAN MODEで演算子として解釈される文字
+var booleanModeOperators = strings.NewReplacer(`"`, " ", "+", " ", "-", " ", "<", " ", ">", " ", "(", " ", ")", " ", "~", " ", "*", " ", "@", " ")
+
+func fulltextText(term searchTerm) string {
+	return strings.Join(strings.Fields(booleanModeOperators.Replace(term.Text)), " ")
+}
+
+// isShortTerm 演算子を取り除いた後の語がFULLTEXTインデックスで検索できない長さか
+func isShortTerm(term searchTerm) bool {
+	return utf8.RuneCountInString(fulltextText(term)) < ngramTokenSize
+}
+
+var likeEscaper = strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`)
+
+// escapeLike LIKEのパターンで語がそのまま一致するよう、ワイルドカードとエスケープ文字をエスケープする
+func escapeLike(s string) string {
+	return likeEscaper.Replace(s)
+}
+
+// buildCondition 検索条件のSQLと、関連度で並べるためのMATCH式を返す
+// 関連度の式はFULLTEXTで検索する語がない場合は空になる
+func (q searchQuery) buildCondition() (condition string, args []interface{}, relevance string, relevanceArgs []interface{}) {
+	var against []string
+	for _, group := range q {
+		short := false
+		for _, term := range group {
+			if isShortTerm(term) {
+				short = true
+				break
+			}
+		}
+
+		// 短い語を含むOR条件はまとめてLIKEで検索する
+		if short {
+			var likes []string
+			for _, term := range group {
+				likes = append(likes, "`courses`.`name` LIKE ? ESCAPE '\\\\' OR `courses`.`keywords` LIKE ? ESCAPE '\\\\' OR `courses`.`description` LIKE ? ESCAPE '\\\\'")
+				pattern := "%" + escapeLike(term.Text) + "%"
+				args = append(args, pattern, pattern, pattern)
+			}
+			condition += " AND (" + strings.Join(likes, " OR ") + ")"
+			continue
+		}
+
+		// ngramパーサでは語はフレーズとして検索される
+		var terms []string
+		for _, term := range group {
+			terms = append(terms, `"`+fulltextText(term)+`"`)
+		}
+		switch len(terms) {
+		case 1:
+			against = append(against, "+"+terms[0])
+		default:
+			against = append(against, "+("+strings.Join(terms, " ")+")")
+		}
+	}
+
+	if len(against) > 0 {
+		expr := strings.Join(against, " ")
+		condition += " AND MATCH (" + fulltextColumns + ") AGAINST (? IN BOOLEAN MODE)"
+		args = append(args, expr)
+		relevance = "MATCH (" + fulltextColumns + ") AGAINST (? IN BOOLEAN MODE)"
+		relevanceArgs = append(relevanceArgs, expr)
+	}
+	return condition, args, relevance, relevanceArgs
+}
+
+type FacetCount struct {
+	Value string `json:"value" db:"value"`
+	Count int    `json:"count" db:"count"`
+}
+
+// SearchFacets 現在の検索条件に一致する科目の、項目の値毎の件数
+// 複数の時限・担当教員を持つ科目はそれぞれの値で数える
+type SearchFacets struct {
+	Type      []FacetCount `json:"type"`
+	Credit    []FacetCount `json:"credit"`
+	Period    []FacetCount `json:"period"`
+	DayOfWeek []FacetCount `json:"day_of_week"`
+	Status    []FacetCount `json:"status"`
+	Teacher   []FacetCount `json:"teacher"`
+}
+
+type SearchCoursesResponse struct {
+	Courses []GetCourseDetailResponse `json:"courses"`
+	Total   int                       `json:"total"`
+	Facets  SearchFacets              `json:"facets"`
+}
+
+// searchFacets 検索条件(WHERE句の1=1に続く部分)に一致する科目の総数とファセットを集計する
+func (h *handlers) searchFacets(filter string, args []interface{}) (int, *SearchFacets, error) {
+	db := h.Balance()
+
+	var total int
+	if err := db.Get(&total, "SELECT COUNT(*) FROM `courses` WHERE 1=1"+filter, args...); err != nil {
+		return 0, nil, err
+	}
+
+	var facets SearchFacets
+	targets := []struct {
+		dest  *[]FacetCount
+		value string
+		join  string
+		order string
+	}{
+		{&facets.Type, "`courses`.`type`", "", "`value`"},
+		{&facets.Credit, "`courses`.`credit`", "", "`value`"},
+		{&facets.Period, "`facet_slots`.`period`", " JOIN `course_slots` AS `facet_slots` ON `facet_slots`.`course_id` = `courses`.`id`", "`value`"},
+		{&facets.DayOfWeek, "`facet_slots`.`day_of_week`", " JOIN `course_slots` AS `facet_slots` ON `facet_slots`.`course_id` = `courses`.`id`", "`value`"},
+		{&facets.Status, "`courses`.`status`", "", "`value`"},
+		{&facets.Teacher, "`facet_users`.`name`", " JOIN `course_staff` AS `facet_staff` ON `facet_staff`.`course_id` = `courses`.`id`" +
+			" JOIN `users` AS `facet_users` ON `facet_users`.`id` = `facet_staff`.`user_id`", "`count` DESC, `value`"},
+	}
+	for _, t := range targets {
+		// 結果が0件の時は空配列を返却
+		*t.dest = make([]FacetCount, 0)
+		query := "SELECT " + t.value + " AS `value`, COUNT(DISTINCT `courses`.`id`) AS `count`" +
+			" FROM `courses`" + t.join +
+			" WHERE 1=1" + filter +
+			" GROUP BY " + t.value +
+			" ORDER BY " + t.order
+		if err := db.Select(t.dest, query, args...); err != nil {
+			return 0, nil, err
+		}
+	}
+	return total, &facets, nil
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseSearchQuery(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+		want  searchQuery
+	}{
+		{"empty", "", nil},
+		{"blank", " 　\t", nil},
+		{"and", "数学 統計", searchQuery{{{Text: "数学"}}, {{Text: "統計"}}}},
+		{"full-width space", "数学　統計", searchQuery{{{Text: "数学"}}, {{Text: "統計"}}}},
+		{"phrase", `"線形 代数"`, searchQuery{{{Text: "線形 代数", Phrase: true}}}},
+		{"phrase spaces are collapsed", `"線形   代数 "`, searchQuery{{{Text: "線形 代数", Phrase: true}}}},
+		{"unterminated phrase", `"線形 代数`, searchQuery{{{Text: "線形 代数", Phrase: true}}}},
+		{"empty phrase is ignored", `"" 数学`, searchQuery{{{Text: "数学"}}}},
+		{"phrase next to a word", `数学"線形 代数"`, searchQuery{{{Text: "数学"}}, {{Text: "線形 代数", Phrase: true}}}},
+		{"or", "数学 OR 統計", searchQuery{{{Text: "数学"}, {Text: "統計"}}}},
+		{"pipe", "数学 | 統計 | 物理", searchQuery{{{Text: "数学"}, {Text: "統計"}, {Text: "物理"}}}},
+		{"or binds tighter than and", "数学 OR 統計 物理", searchQuery{{{Text: "数学"}, {Text: "統計"}}, {{Text: "物理"}}}},
+		{"leading or is ignored", "OR 数学", searchQuery{{{Text: "数学"}}}},
+		{"quoted or is a phrase", `数学 "OR" 統計`, searchQuery{{{Text: "数学"}}, {{Text: "OR", Phrase: true}}, {{Text: "統計"}}}},
+		{"lowercase or is a word", "数学 or 統計", searchQuery{{{Text: "数学"}}, {{Text: "or"}}, {{Text: "統計"}}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := parseSearchQuery(tt.input); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("parseSearchQuery(%q) = %#v, want %#v", tt.input, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestBuildCondition(t *testing.T) {
+	const like = "`courses`.`name` LIKE ? ESCAPE '\\\\' OR `courses`.`keywords` LIKE ? ESCAPE '\\\\' OR `courses`.`description` LIKE ? ESCAPE '\\\\'"
+	const match = "MATCH (" + fulltextColumns + ") AGAINST (? IN BOOLEAN MODE)"
+
+	tests := []struct {
+		name          string
+		input         string
+		condition     string
+		args          []interface{}
+		relevance     string
+		relevanceArgs []interface{}
+	}{
+		{
+			name:  "empty",
+			input: "",
+		},
+		{
+			name:          "fulltext",
+			input:         "数学 統計",
+			condition:     " AND " + match,
+			args:          []interface{}{`+"数学" +"統計"`},
+			relevance:     match,
+			relevanceArgs: []interface{}{`+"数学" +"統計"`},
+		},
+		{
+			name:          "or group",
+			input:         `数学 OR "線形 代数"`,
+			condition:     " AND " + match,
+			args:          []interface{}{`+("数学" "線形 代数")`},
+			relevance:     match,
+			relevanceArgs: []interface{}{`+("数学" "線形 代数")`},
+		},
+		{
+			name:          "operators are removed",
+			input:         "+数学* -統計",
+			condition:     " AND " + match,
+			args:          []interface{}{`+"数学" +"統計"`},
+			relevance:     match,
+			relevanceArgs: []interface{}{`+"数学" +"統計"`},
+		},
+		{
+			name:      "short term uses like",
+			input:     "C",
+			condition: " AND (" + like + ")",
+			args:      []interface{}{"%C%", "%C%", "%C%"},
+		},
+		{
+			name:      "or group with a short term uses like for all terms",
+			input:     "C OR 数学",
+			condition: " AND (" + like + " OR " + like + ")",
+			args:      []interface{}{"%C%", "%C%", "%C%", "%数学%", "%数学%", "%数学%"},
+		},
+		{
+			name:          "like and fulltext",
+			input:         "C 数学",
+			condition:     " AND (" + like + ") AND " + match,
+			args:          []interface{}{"%C%", "%C%", "%C%", `+"数学"`},
+			relevance:     match,
+			relevanceArgs: []interface{}{`+"数学"`},
+		},
+		{
+			name:      "like wildcards are escaped",
+			input:     `% _ \`,
+			condition: " AND (" + like + ") AND (" + like + ") AND (" + like + ")",
+			args:      []interface{}{`%\%%`, `%\%%`, `%\%%`, `%\_%`, `%\_%`, `%\_%`, `%\\%`, `%\\%`, `%\\%`},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			condition, args, relevance, relevanceArgs := parseSearchQuery(tt.input).buildCondition()
+			if condition != tt.condition {
+				t.Errorf("condition = %q, want %q", condition, tt.condition)
+			}
+			if !reflect.DeepEqual(args, tt.args) {
+				t.Errorf("args = %#v, want %#v", args, tt.args)
+			}
+			if relevance != tt.relevance {
+				t.Errorf("relevance = %q, want %q", relevance, tt.relevance)
+			}
+			if !reflect.DeepEqual(relevanceArgs, tt.relevanceArgs) {
+				t.Errorf("relevanceArgs = %#v, want %#v", relevanceArgs, tt.relevanceArgs)
+			}
+		})
+	}
+}
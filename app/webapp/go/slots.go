@@ -0,0 +1,139 @@
+package main
+
+import (
+	"sort"
+	"sync"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// CourseSlot 科目の毎週の開講時限
+type CourseSlot struct {
+	DayOfWeek DayOfWeek `json:"day_of_week" db:"day_of_week"`
+	Period    uint8     `json:"period" db:"period"`
+}
+
+var (
+	CourseSlotCacheMap = make(map[string][]CourseSlot)
+	CourseSlotCacheMux = sync.RWMutex{}
+)
+
+func sortSlots(slots []CourseSlot) {
+	sort.Slice(slots, func(i, j int) bool {
+		if slots[i].DayOfWeek != slots[j].DayOfWeek {
+			return dayOfWeekIndex(slots[i].DayOfWeek) < dayOfWeekIndex(slots[j].DayOfWeek)
+		}
+		return slots[i].Period < slots[j].Period
+	})
+}
+
+// getCourseSlots 科目の時限一覧。course_slotsに行がなければ代表の時限のみとみなす
+func (h *handlers) getCourseSlots(courseID string, primary CourseSlot) ([]CourseSlot, error) {
+	CourseSlotCacheMux.RLock()
+	slots, ok := CourseSlotCacheMap[courseID]
+	CourseSlotCacheMux.RUnlock()
+	if !ok {
+		var err error
+		if slots, err = h.loadCourseSlots(courseID); err != nil {
+			return nil, err
+		}
+	}
+	if len(slots) == 0 {
+		return []CourseSlot{primary}, nil
+	}
+	return slots, nil
+}
+
+func (h *handlers) loadCourseSlots(courseID string) ([]CourseSlot, error) {
+	slots := []CourseSlot{}
+	if err := h.Balance().Select(&slots, "SELECT `day_of_week`, `period` FROM `course_slots` WHERE `course_id` = ?", courseID); err != nil {
+		return nil, err
+	}
+	sortSlots(slots)
+	CourseSlotCacheMux.Lock()
+	CourseSlotCacheMap[courseID] = slots
+	CourseSlotCacheMux.Unlock()
+	return slots, nil
+}
+
+func (course *Course) primarySlot() CourseSlot {
+	return CourseSlot{DayOfWeek: course.DayOfWeek, Period: course.Period}
+}
+
+// validateSlots 時限の重複や範囲外の値がないか
+func validateSlots(slots []CourseSlot) bool {
+	if len(slots) == 0 {
+		return false
+	}
+	seen := make(map[CourseSlot]bool, len(slots))
+	for _, slot := range slots {
+		if !contains(daysOfWeek, slot.DayOfWeek) || slot.Period == 0 || slot.Period > periodCount || seen[slot] {
+			return false
+		}
+		seen[slot] = true
+	}
+	return true
+}
+
+// setCourseSlots 科目の時限を置き換える。キャッシュはコミット後にcacheCourseSlotsで更新する
+func (h *handlers) setCourseSlots(db sqlx.Execer, sub sqlx.Execer, courseID string, slots []CourseSlot) error {
+	sub.Exec("DELETE FROM `course_slots` WHERE `course_id` = ?", courseID)
+	if _, err := db.Exec("DELETE FROM `course_slots` WHERE `course_id` = ?", courseID); err != nil {
+		return err
+	}
+	return h.insertCourseSlots(db, sub, courseID, slots)
+}
+
+// insertCourseSlots 新しく登録した科目の時限を登録する
+func (h *handlers) insertCourseSlots(db sqlx.Execer, sub sqlx.Execer, courseID string, slots []CourseSlot) error {
+	for _, slot := range slots {
+		sub.Exec("INSERT INTO `course_slots` (`course_id`, `day_of_week`, `period`) VALUES (?, ?, ?)", courseID, slot.DayOfWeek, slot.Period)
+		if _, err := db.Exec("INSERT INTO `course_slots` (`course_id`, `day_of_week`, `period`) VALUES (?, ?, ?)", courseID, slot.DayOfWeek, slot.Period); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func cacheCourseSlots(courseID string, slots []CourseSlot) {
+	cached := append([]CourseSlot{}, slots...)
+	sortSlots(cached)
+	CourseSlotCacheMux.Lock()
+	CourseSlotCacheMap[courseID] = cached
+	CourseSlotCacheMux.Unlock()
+}
+
+func equalSlots(slots1 []CourseSlot, slots2 []CourseSlot) bool {
+	if len(slots1) != len(slots2) {
+		return false
+	}
+	for i := range slots1 {
+		if slots1[i] != slots2[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// schedulesConflict 二つの科目の時間割がどこかの時限で重なっているか
+func (h *handlers) schedulesConflict(course1 *Course, course2 *Course) (bool, error) {
+	if course1.ID == course2.ID {
+		return false, nil
+	}
+	slots1, err := h.getCourseSlots(course1.ID, course1.primarySlot())
+	if err != nil {
+		return false, err
+	}
+	slots2, err := h.getCourseSlots(course2.ID, course2.primarySlot())
+	if err != nil {
+		return false, err
+	}
+	for _, slot1 := range slots1 {
+		for _, slot2 := range slots2 {
+			if slot1 == slot2 {
+				return true, nil
+			}
+		}
+	}
+	return false, nil
+}
@@ -0,0 +1,51 @@
+package main
+
+import "testing"
+
+func TestSchedulesConflict(t *testing.T) {
+	h := &handlers{}
+	course := func(id string, day DayOfWeek, period uint8, slots ...CourseSlot) *Course {
+		// 時限を登録していない科目は空のリストをキャッシュして代表の時限を使わせる
+		cacheCourseSlots(id, slots)
+		return &Course{ID: id, DayOfWeek: day, Period: period}
+	}
+
+	mon1 := CourseSlot{DayOfWeek: Monday, Period: 1}
+	wed2 := CourseSlot{DayOfWeek: Wednesday, Period: 2}
+	fri3 := CourseSlot{DayOfWeek: Friday, Period: 3}
+
+	tests := []struct {
+		name    string
+		course1 *Course
+		course2 *Course
+		want    bool
+	}{
+		{"same primary slot", course("test-a1", Monday, 1), course("test-a2", Monday, 1), true},
+		{"different primary slots", course("test-b1", Monday, 1), course("test-b2", Monday, 2), false},
+		{"same period on another day", course("test-c1", Monday, 1), course("test-c2", Tuesday, 1), false},
+		{"overlap in a secondary slot", course("test-d1", Monday, 1, mon1, wed2), course("test-d2", Friday, 3, fri3, wed2), true},
+		{"no overlap in any slot", course("test-e1", Monday, 1, mon1, wed2), course("test-e2", Friday, 3, fri3), false},
+		{"secondary slot against a primary slot", course("test-f1", Monday, 1, mon1, wed2), course("test-f2", Wednesday, 2), true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := h.schedulesConflict(tt.course1, tt.course2)
+			if err != nil {
+				t.Fatalf("schedulesConflict() error = %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("schedulesConflict() = %v, want %v", got, tt.want)
+			}
+			if got, _ := h.schedulesConflict(tt.course2, tt.course1); got != tt.want {
+				t.Errorf("schedulesConflict() with swapped arguments = %v, want %v", got, tt.want)
+			}
+		})
+	}
+
+	t.Run("same course", func(t *testing.T) {
+		c := course("test-g1", Monday, 1, mon1, wed2)
+		if got, err := h.schedulesConflict(c, c); err != nil || got {
+			t.Errorf("schedulesConflict() = (%v, %v), want (false, nil)", got, err)
+		}
+	})
+}
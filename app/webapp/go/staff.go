@@ -0,0 +1,295 @@
+package main
+
+import (
+	"database/sql"
+	"net/http"
+	"sort"
+	"sync"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/labstack/echo/v4"
+)
+
+type StaffRole string
+
+const (
+	RoleOwner     StaffRole = "owner"
+	RoleCoTeacher StaffRole = "co-teacher"
+)
+
+// CourseStaff 科目の担当教員。ownerは courses.teacher_id と同じ教員で、科目に必ず一人だけいる
+type CourseStaff struct {
+	UserID string    `json:"user_id" db:"user_id"`
+	Code   string    `json:"code" db:"code"`
+	Name   string    `json:"name" db:"name"`
+	Role   StaffRole `json:"role" db:"role"`
+}
+
+var (
+	CourseStaffCacheMap = make(map[string][]CourseStaff)
+	CourseStaffCacheMux = sync.RWMutex{}
+)
+
+// getCourseStaff 科目の担当教員一覧(ownerが先頭、以降は教員コード順)
+func (h *handlers) getCourseStaff(courseID string) ([]CourseStaff, error) {
+	CourseStaffCacheMux.RLock()
+	if staff, ok := CourseStaffCacheMap[courseID]; ok {
+		CourseStaffCacheMux.RUnlock()
+		return staff, nil
+	}
+	CourseStaffCacheMux.RUnlock()
+
+	staff := []CourseStaff{}
+	query := "SELECT `users`.`id` AS `user_id`, `users`.`code`, `users`.`name`, `course_staff`.`role`" +
+		" FROM `course_staff`" +
+		" JOIN `users` ON `users`.`id` = `course_staff`.`user_id`" +
+		" WHERE `course_staff`.`course_id` = ?"
+	if err := h.Balance().Select(&staff, query, courseID); err != nil {
+		return nil, err
+	}
+	sort.Slice(staff, func(i, j int) bool {
+		if staff[i].Role != staff[j].Role {
+			return staff[i].Role == RoleOwner
+		}
+		return staff[i].Code < staff[j].Code
+	})
+	CourseStaffCacheMux.Lock()
+	CourseStaffCacheMap[courseID] = staff
+	CourseStaffCacheMux.Unlock()
+	return staff, nil
+}
+
+// discardCourseStaff 担当教員を変更したらコミット後に呼ぶ
+func discardCourseStaff(courseID string) {
+	CourseStaffCacheMux.Lock()
+	delete(CourseStaffCacheMap, courseID)
+	CourseStaffCacheMux.Unlock()
+}
+
+// isCourseStaff 教員が科目の担当(ownerまたはco-teacher)かどうか
+func (h *handlers) isCourseStaff(courseID string, userID string) (bool, error) {
+	staff, err := h.getCourseStaff(courseID)
+	if err != nil {
+		return false, err
+	}
+	for _, s := range staff {
+		if s.UserID == userID {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+func (h *handlers) insertCourseStaff(db sqlx.Execer, sub sqlx.Execer, courseID string, userID string, role StaffRole) error {
+	query := "INSERT INTO `course_staff` (`course_id`, `user_id`, `role`) VALUES (?, ?, ?) ON DUPLICATE KEY UPDATE `role` = VALUES(`role`)"
+	sub.Exec(query, courseID, userID, role)
+	_, err := db.Exec(query, courseID, userID, role)
+	return err
+}
+
+// getTeacherByCode 教員コードから教員を取得する。存在しない場合や学生の場合はnil
+func getTeacherByCode(db sqlx.Queryer, code string) (*User, error) {
+	var user User
+	if err := sqlx.Get(db, &user, "SELECT * FROM `users` WHERE `code` = ?", code); err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil
+		}
+		return nil, err
+	}
+	if user.Type != Teacher {
+		return nil, nil
+	}
+	return &user, nil
+}
+
+// GetCourseStaff GET /api/courses/:courseID/staff 科目の担当教員一覧の取得
+func (h *handlers) GetCourseStaff(c echo.Context) error {
+	courseID := c.Param("courseID")
+	if ok, _ := h.getCourse(courseID); !ok {
+		return c.String(http.StatusNotFound, "No such course.")
+	}
+
+	staff, err := h.getCourseStaff(courseID)
+	if err != nil {
+		c.Logger().Error(err)
+		return c.NoContent(http.StatusInternalServerError)
+	}
+	return c.JSON(http.StatusOK, staff)
+}
+
+type AddCourseStaffRequest struct {
+	Code string `json:"code"`
+}
+
+// AddCourseStaff POST /api/courses/:courseID/staff 共同担当教員の追加(ownerのみ)
+func (h *handlers) AddCourseStaff(c echo.Context) error {
+	userID, _, _, err := getUserInfo(c)
+	if err != nil {
+		c.Logger().Error(err)
+		return c.NoContent(http.StatusInternalServerError)
+	}
+
+	courseID := c.Param("courseID")
+
+	var req AddCourseStaffRequest
+	if err := c.Bind(&req); err != nil {
+		return c.String(http.StatusBadRequest, "Invalid format.")
+	}
+
+	ok, course := h.getCourse(courseID)
+	if !ok {
+		return c.String(http.StatusNotFound, "No such course.")
+	}
+	if course.TeacherID != userID {
+		return c.String(http.StatusForbidden, "You are not the owner of this course.")
+	}
+
+	teacher, err := getTeacherByCode(h.Balance(), req.Code)
+	if err != nil {
+		c.Logger().Error(err)
+		return c.NoContent(http.StatusInternalServerError)
+	}
+	if teacher == nil {
+		return c.String(http.StatusBadRequest, "No such teacher.")
+	}
+	// ownerは共同担当にできない。すでに共同担当の場合は何もしない
+	if teacher.ID == course.TeacherID {
+		return c.String(http.StatusBadRequest, "The teacher is the owner of this course.")
+	}
+
+	if err := h.insertCourseStaff(h.DB, h.SubDB, courseID, teacher.ID, RoleCoTeacher); err != nil {
+		c.Logger().Error(err)
+		return c.NoContent(http.StatusInternalServerError)
+	}
+	discardCourseStaff(courseID)
+
+	bumpVersion(catalogKey, courseKey(courseID))
+	return c.NoContent(http.StatusOK)
+}
+
+// RemoveCourseStaff DELETE /api/courses/:courseID/staff/:userID 共同担当教員の削除(ownerのみ)
+func (h *handlers) RemoveCourseStaff(c echo.Context) error {
+	userID, _, _, err := getUserInfo(c)
+	if err != nil {
+		c.Logger().Error(err)
+		return c.NoContent(http.StatusInternalServerError)
+	}
+
+	courseID := c.Param("courseID")
+	staffID := c.Param("userID")
+
+	ok, course := h.getCourse(courseID)
+	if !ok {
+		return c.String(http.StatusNotFound, "No such course.")
+	}
+	if course.TeacherID != userID {
+		return c.String(http.StatusForbidden, "You are not the owner of this course.")
+	}
+	if staffID == course.TeacherID {
+		return c.String(http.StatusBadRequest, "The owner cannot be removed. Reassign the owner first.")
+	}
+
+	query := "DELETE FROM `course_staff` WHERE `course_id` = ? AND `user_id` = ? AND `role` = ?"
+	h.SubDB.Exec(query, courseID, staffID, RoleCoTeacher)
+	result, err := h.DB.Exec(query, courseID, staffID, RoleCoTeacher)
+	if err != nil {
+		c.Logger().Error(err)
+		return c.NoContent(http.StatusInternalServerError)
+	}
+	if n, err := result.RowsAffected(); err != nil {
+		c.Logger().Error(err)
+		return c.NoContent(http.StatusInternalServerError)
+	} else if n == 0 {
+		return c.String(http.StatusNotFound, "No such staff member.")
+	}
+	discardCourseStaff(courseID)
+
+	bumpVersion(catalogKey, courseKey(courseID))
+	return c.NoContent(http.StatusNoContent)
+}
+
+type ReassignCourseOwnerRequest struct {
+	Code string `json:"code"`
+}
+
+// ReassignCourseOwner PUT /api/courses/:courseID/owner 科目のownerを別の教員に変更(ownerのみ)
+// 元のownerは共同担当として残る
+func (h *handlers) ReassignCourseOwner(c echo.Context) error {
+	userID, _, _, err := getUserInfo(c)
+	if err != nil {
+		c.Logger().Error(err)
+		return c.NoContent(http.StatusInternalServerError)
+	}
+
+	courseID := c.Param("courseID")
+
+	var req ReassignCourseOwnerRequest
+	if err := c.Bind(&req); err != nil {
+		return c.String(http.StatusBadRequest, "Invalid format.")
+	}
+
+	ok, current := h.getCourse(courseID)
+	if !ok {
+		return c.String(http.StatusNotFound, "No such course.")
+	}
+	if current.TeacherID != userID {
+		return c.String(http.StatusForbidden, "You are not the owner of this course.")
+	}
+
+	teacher, err := getTeacherByCode(h.Balance(), req.Code)
+	if err != nil {
+		c.Logger().Error(err)
+		return c.NoContent(http.StatusInternalServerError)
+	}
+	if teacher == nil {
+		return c.String(http.StatusBadRequest, "No such teacher.")
+	}
+	if teacher.ID == current.TeacherID {
+		return c.NoContent(http.StatusOK)
+	}
+
+	tx, err := h.DB.Beginx()
+	if err != nil {
+		c.Logger().Error(err)
+		return c.NoContent(http.StatusInternalServerError)
+	}
+	defer tx.Rollback()
+
+	query := "UPDATE `courses` SET `teacher_id` = ?, `version` = `version` + 1 WHERE `id` = ? AND `teacher_id` = ?"
+	h.SubDB.Exec(query, teacher.ID, courseID, current.TeacherID)
+	result, err := tx.Exec(query, teacher.ID, courseID, current.TeacherID)
+	if err != nil {
+		c.Logger().Error(err)
+		return c.NoContent(http.StatusInternalServerError)
+	}
+	if n, err := result.RowsAffected(); err != nil {
+		c.Logger().Error(err)
+		return c.NoContent(http.StatusInternalServerError)
+	} else if n == 0 {
+		return c.String(http.StatusConflict, "The owner of this course has been changed.")
+	}
+	if err := h.insertCourseStaff(tx, h.SubDB, courseID, current.TeacherID, RoleCoTeacher); err != nil {
+		c.Logger().Error(err)
+		return c.NoContent(http.StatusInternalServerError)
+	}
+	if err := h.insertCourseStaff(tx, h.SubDB, courseID, teacher.ID, RoleOwner); err != nil {
+		c.Logger().Error(err)
+		return c.NoContent(http.StatusInternalServerError)
+	}
+
+	if err := tx.Commit(); err != nil {
+		c.Logger().Error(err)
+		return c.NoContent(http.StatusInternalServerError)
+	}
+
+	course := *current
+	course.TeacherID = teacher.ID
+	course.Version++
+	CourseCacheMux.Lock()
+	CourseCacheMap[courseID] = &course
+	CourseCacheMux.Unlock()
+	discardCourseStaff(courseID)
+
+	bumpVersion(catalogKey, courseKey(courseID))
+	return c.NoContent(http.StatusOK)
+}
@@ -0,0 +1,254 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/labstack/echo/v4"
+)
+
+// シラバス(科目の説明とキーワード)の版
+// 科目の作成時に第1版を記録し、説明かキーワードが変わる度に版を追加する
+
+type SyllabusRevision struct {
+	Revision    int       `json:"revision" db:"revision"`
+	Description string    `json:"description" db:"description"`
+	Keywords    string    `json:"keywords" db:"keywords"`
+	AuthorCode  string    `json:"author_code" db:"author_code"`
+	AuthorName  string    `json:"author_name" db:"author_name"`
+	CreatedAt   time.Time `json:"created_at" db:"created_at"`
+}
+
+// addSyllabusRevision 科目の現在のシラバスを新しい版として記録し、版番号を返す
+// 同じ科目の更新はcoursesの行ロック(versionの更新)で直列化されている前提
+func (h *handlers) addSyllabusRevision(db sqlx.Ext, sub sqlx.Execer, course *Course, authorID string) (int, error) {
+	var revision int
+	if err := sqlx.Get(db, &revision, "SELECT IFNULL(MAX(`revision`), 0) + 1 FROM `syllabus_revisions` WHERE `course_id` = ?", course.ID); err != nil {
+		return 0, err
+	}
+	createdAt := time.Now().Truncate(time.Microsecond)
+	query := "INSERT INTO `syllabus_revisions` (`course_id`, `revision`, `description`, `keywords`, `author_id`, `created_at`) VALUES (?, ?, ?, ?, ?, ?)"
+	sub.Exec(query, course.ID, revision, course.Description, course.Keywords, authorID, createdAt)
+	if _, err := db.Exec(query, course.ID, revision, course.Description, course.Keywords, authorID, createdAt); err != nil {
+		return 0, err
+	}
+	return revision, nil
+}
+
+// recordSyllabusChange シラバスの版を追加し、履修登録が始まった後であれば履修者にお知らせで通知する
+// 通知した学生のIDを返すので、コミット後にbumpAnnouncementsを呼ぶこと
+func (h *handlers) recordSyllabusChange(tx *sqlx.Tx, course *Course, authorID string) ([]string, error) {
+	revision, err := h.addSyllabusRevision(tx, h.SubDB, course, authorID)
+	if err != nil {
+		return nil, err
+	}
+	if course.Status == StatusRegistration && !h.isCourseRegistrationOpen(course) {
+		return nil, nil
+	}
+
+	title := "シラバス更新: " + course.Name
+	message := fmt.Sprintf("%sのシラバスが第%d版に更新されました。変更内容は /api/courses/%s/syllabus/diff?from=%d&to=%d で確認できます。",
+		course.Name, revision, course.ID, revision-1, revision)
+	return h.notifyRegisteredStudents(tx, course.ID, title, message)
+}
+
+// notifyRegisteredStudents 科目の履修者全員に未読のお知らせを追加し、対象の学生のIDを返す
+func (h *handlers) notifyRegisteredStudents(tx *sqlx.Tx, courseID string, title string, message string) ([]string, error) {
+	var targets []string
+	if err := tx.Select(&targets, "SELECT `user_id` FROM `registrations` WHERE `course_id` = ?", courseID); err != nil {
+		return nil, err
+	}
+	if len(targets) == 0 {
+		return nil, nil
+	}
+
+	announcementID := newULID()
+	h.SubDB.Exec("INSERT INTO `announcements` (`id`, `course_id`, `title`, `message`) VALUES (?, ?, ?, ?)", announcementID, courseID, title, message)
+	if _, err := tx.Exec("INSERT INTO `announcements` (`id`, `course_id`, `title`, `message`) VALUES (?, ?, ?, ?)", announcementID, courseID, title, message); err != nil {
+		return nil, err
+	}
+
+	placeholders := make([]string, 0, len(targets))
+	args := make([]interface{}, 0, len(targets)*2)
+	for _, userID := range targets {
+		placeholders = append(placeholders, "(?, ?)")
+		args = append(args, announcementID, userID)
+	}
+	query := "INSERT INTO `unread_announcements` (`announcement_id`, `user_id`) VALUES " + strings.Join(placeholders, ",")
+	h.SubDB.Exec(query, args...)
+	if _, err := tx.Exec(query, args...); err != nil {
+		return nil, err
+	}
+	return targets, nil
+}
+
+func bumpAnnouncements(userIDs []string) {
+	if len(userIDs) == 0 {
+		return
+	}
+	keys := make([]string, 0, len(userIDs))
+	for _, userID := range userIDs {
+		keys = append(keys, announcementsKey(userID))
+	}
+	bumpVersion(keys...)
+}
+
+func (h *handlers) getSyllabusRevisions(courseID string) ([]SyllabusRevision, error) {
+	// 結果が0件の時は空配列を返却
+	revisions := make([]SyllabusRevision, 0)
+	query := "SELECT `syllabus_revisions`.`revision`, `syllabus_revisions`.`description`, `syllabus_revisions`.`keywords`," +
+		" `users`.`code` AS `author_code`, `users`.`name` AS `author_name`, `syllabus_revisions`.`created_at`" +
+		" FROM `syllabus_revisions`" +
+		" JOIN `users` ON `users`.`id` = `syllabus_revisions`.`author_id`" +
+		" WHERE `syllabus_revisions`.`course_id` = ?" +
+		" ORDER BY `syllabus_revisions`.`revision` DESC"
+	if err := h.Balance().Select(&revisions, query, courseID); err != nil {
+		return nil, err
+	}
+	return revisions, nil
+}
+
+// GetSyllabusRevisions GET /api/courses/:courseID/syllabus/revisions シラバスの変更履歴(新しい版から)
+func (h *handlers) GetSyllabusRevisions(c echo.Context) error {
+	courseID := c.Param("courseID")
+	if ok, _ := h.getCourse(courseID); !ok {
+		return c.String(http.StatusNotFound, "No such course.")
+	}
+
+	revisions, err := h.getSyllabusRevisions(courseID)
+	if err != nil {
+		c.Logger().Error(err)
+		return c.NoContent(http.StatusInternalServerError)
+	}
+	return c.JSON(http.StatusOK, revisions)
+}
+
+type DiffOp string
+
+const (
+	DiffEqual  DiffOp = "equal"
+	DiffInsert DiffOp = "insert"
+	DiffDelete DiffOp = "delete"
+)
+
+type DiffLine struct {
+	Op   DiffOp `json:"op"`
+	Text string `json:"text"`
+}
+
+// diffLines 最長共通部分列による差分。説明は行単位、キーワードは語単位で使う
+func diffLines(a []string, b []string) []DiffLine {
+	// lcs[i][j] は a[i:] と b[j:] の最長共通部分列の長さ
+	lcs := make([][]int, len(a)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(b)+1)
+	}
+	for i := len(a) - 1; i >= 0; i-- {
+		for j := len(b) - 1; j >= 0; j-- {
+			if a[i] == b[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else if lcs[i+1][j] >= lcs[i][j+1] {
+				lcs[i][j] = lcs[i+1][j]
+			} else {
+				lcs[i][j] = lcs[i][j+1]
+			}
+		}
+	}
+
+	diff := make([]DiffLine, 0, len(a)+len(b))
+	i, j := 0, 0
+	for i < len(a) && j < len(b) {
+		switch {
+		case a[i] == b[j]:
+			diff = append(diff, DiffLine{Op: DiffEqual, Text: a[i]})
+			i++
+			j++
+		case lcs[i+1][j] >= lcs[i][j+1]:
+			diff = append(diff, DiffLine{Op: DiffDelete, Text: a[i]})
+			i++
+		default:
+			diff = append(diff, DiffLine{Op: DiffInsert, Text: b[j]})
+			j++
+		}
+	}
+	for ; i < len(a); i++ {
+		diff = append(diff, DiffLine{Op: DiffDelete, Text: a[i]})
+	}
+	for ; j < len(b); j++ {
+		diff = append(diff, DiffLine{Op: DiffInsert, Text: b[j]})
+	}
+	return diff
+}
+
+type SyllabusDiffResponse struct {
+	From        int        `json:"from"`
+	To          int        `json:"to"`
+	Description []DiffLine `json:"description"`
+	Keywords    []DiffLine `json:"keywords"`
+}
+
+// GetSyllabusDiff GET /api/courses/:courseID/syllabus/diff シラバスの版同士の差分
+// from, to は版番号。省略時はtoが最新版、fromがその一つ前の版
+func (h *handlers) GetSyllabusDiff(c echo.Context) error {
+	courseID := c.Param("courseID")
+	if ok, _ := h.getCourse(courseID); !ok {
+		return c.String(http.StatusNotFound, "No such course.")
+	}
+
+	revisions, err := h.getSyllabusRevisions(courseID)
+	if err != nil {
+		c.Logger().Error(err)
+		return c.NoContent(http.StatusInternalServerError)
+	}
+	if len(revisions) == 0 {
+		return c.String(http.StatusNotFound, "No syllabus revisions.")
+	}
+
+	to := revisions[0].Revision
+	if s := c.QueryParam("to"); s != "" {
+		if to, err = strconv.Atoi(s); err != nil {
+			return c.String(http.StatusBadRequest, "Invalid revision.")
+		}
+	}
+	from := to - 1
+	if s := c.QueryParam("from"); s != "" {
+		if from, err = strconv.Atoi(s); err != nil {
+			return c.String(http.StatusBadRequest, "Invalid revision.")
+		}
+	}
+
+	// 第0版は空のシラバスとして扱う
+	find := func(revision int) (*SyllabusRevision, bool) {
+		if revision == 0 {
+			return &SyllabusRevision{}, true
+		}
+		for i := range revisions {
+			if revisions[i].Revision == revision {
+				return &revisions[i], true
+			}
+		}
+		return nil, false
+	}
+	fromRev, ok1 := find(from)
+	toRev, ok2 := find(to)
+	if !ok1 || !ok2 {
+		return c.String(http.StatusNotFound, "No such revision.")
+	}
+
+	splitLines := func(s string) []string {
+		if s == "" {
+			return nil
+		}
+		return strings.Split(strings.ReplaceAll(s, "\r\n", "\n"), "\n")
+	}
+	return c.JSON(http.StatusOK, SyllabusDiffResponse{
+		From:        from,
+		To:          to,
+		Description: diffLines(splitLines(fromRev.Description), splitLines(toRev.Description)),
+		Keywords:    diffLines(strings.Fields(fromRev.Keywords), strings.Fields(toRev.Keywords)),
+	})
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestDiffLines(t *testing.T) {
+	tests := []struct {
+		name string
+		a    []string
+		b    []string
+		want []DiffLine
+	}{
+		{"both empty", nil, nil, []DiffLine{}},
+		{
+			"all inserted",
+			nil,
+			[]string{"a", "b"},
+			[]DiffLine{{DiffInsert, "a"}, {DiffInsert, "b"}},
+		},
+		{
+			"all deleted",
+			[]string{"a", "b"},
+			nil,
+			[]DiffLine{{DiffDelete, "a"}, {DiffDelete, "b"}},
+		},
+		{
+			"unchanged",
+			[]string{"a", "b"},
+			[]string{"a", "b"},
+			[]DiffLine{{DiffEqual, "a"}, {DiffEqual, "b"}},
+		},
+		{
+			"line replaced",
+			[]string{"a", "b", "c"},
+			[]string{"a", "x", "c"},
+			[]DiffLine{{DiffEqual, "a"}, {DiffDelete, "b"}, {DiffInsert, "x"}, {DiffEqual, "c"}},
+		},
+		{
+			"line inserted and deleted",
+			[]string{"a", "b", "c"},
+			[]string{"b", "c", "d"},
+			[]DiffLine{{DiffDelete, "a"}, {DiffEqual, "b"}, {DiffEqual, "c"}, {DiffInsert, "d"}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := diffLines(tt.a, tt.b); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("diffLines() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,218 @@
+package main
+
+import (
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/go-sql-driver/mysql"
+	"github.com/labstack/echo/v4"
+)
+
+const dateFormat = "2006-01-02"
+
+type Term struct {
+	ID                string    `db:"id"`
+	Name              string    `db:"name"`
+	StartDate         time.Time `db:"start_date"`
+	EndDate           time.Time `db:"end_date"`
+	RegistrationStart time.Time `db:"registration_start"`
+	RegistrationEnd   time.Time `db:"registration_end"`
+}
+
+// isRegistrationOpen 履修登録期間中かどうか(期間の両端を含む)
+func (t *Term) isRegistrationOpen(now time.Time) bool {
+	today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.UTC)
+	return !today.Before(t.RegistrationStart) && !today.After(t.RegistrationEnd)
+}
+
+var (
+	TermCacheMap = make(map[string]*Term)
+	TermCacheMux = sync.RWMutex{}
+)
+
+func (h *handlers) getTerm(termID string) (bool, *Term) {
+	TermCacheMux.RLock()
+	if term, ok := TermCacheMap[termID]; ok {
+		TermCacheMux.RUnlock()
+		return true, term
+	}
+	TermCacheMux.RUnlock()
+
+	term := &Term{}
+	if err := h.Balance().Get(term, "SELECT * FROM `terms` WHERE `id` = ?", termID); err != nil {
+		return false, nil
+	}
+	TermCacheMux.Lock()
+	TermCacheMap[termID] = term
+	TermCacheMux.Unlock()
+	return true, term
+}
+
+// isCourseRegistrationOpen 学期が設定されている科目は学期の履修登録期間内のみ登録できる
+func (h *handlers) isCourseRegistrationOpen(course *Course) bool {
+	if !course.TermID.Valid {
+		return true
+	}
+	ok, term := h.getTerm(course.TermID.String)
+	if !ok {
+		return true
+	}
+	return term.isRegistrationOpen(time.Now())
+}
+
+type TermResponse struct {
+	ID                string `json:"id"`
+	Name              string `json:"name"`
+	StartDate         string `json:"start_date"`
+	EndDate           string `json:"end_date"`
+	RegistrationStart string `json:"registration_start"`
+	RegistrationEnd   string `json:"registration_end"`
+}
+
+func newTermResponse(term *Term) TermResponse {
+	return TermResponse{
+		ID:                term.ID,
+		Name:              term.Name,
+		StartDate:         term.StartDate.Format(dateFormat),
+		EndDate:           term.EndDate.Format(dateFormat),
+		RegistrationStart: term.RegistrationStart.Format(dateFormat),
+		RegistrationEnd:   term.RegistrationEnd.Format(dateFormat),
+	}
+}
+
+// GetTerms GET /api/terms 学期一覧の取得
+func (h *handlers) GetTerms(c echo.Context) error {
+	var terms []Term
+	if err := h.Balance().Select(&terms, "SELECT * FROM `terms` ORDER BY `start_date`"); err != nil {
+		c.Logger().Error(err)
+		return c.NoContent(http.StatusInternalServerError)
+	}
+
+	// 結果が0件の時は空配列を返却
+	res := make([]TermResponse, 0, len(terms))
+	for i := range terms {
+		res = append(res, newTermResponse(&terms[i]))
+	}
+
+	return c.JSON(http.StatusOK, res)
+}
+
+type AddTermRequest struct {
+	Name              string `json:"name"`
+	StartDate         string `json:"start_date"`
+	EndDate           string `json:"end_date"`
+	RegistrationStart string `json:"registration_start"`
+	RegistrationEnd   string `json:"registration_end"`
+}
+
+type AddTermResponse struct {
+	ID string `json:"id"`
+}
+
+// AddTerm POST /api/terms 新規学期登録
+func (h *handlers) AddTerm(c echo.Context) error {
+	var req AddTermRequest
+	if err := c.Bind(&req); err != nil {
+		return c.String(http.StatusBadRequest, "Invalid format.")
+	}
+
+	if req.Name == "" {
+		return c.String(http.StatusBadRequest, "Invalid term name.")
+	}
+	dates := make([]time.Time, 4)
+	for i, s := range []string{req.StartDate, req.EndDate, req.RegistrationStart, req.RegistrationEnd} {
+		d, err := time.Parse(dateFormat, s)
+		if err != nil {
+			return c.String(http.StatusBadRequest, "Invalid date.")
+		}
+		dates[i] = d
+	}
+	term := &Term{
+		ID:                newULID(),
+		Name:              req.Name,
+		StartDate:         dates[0],
+		EndDate:           dates[1],
+		RegistrationStart: dates[2],
+		RegistrationEnd:   dates[3],
+	}
+	if term.EndDate.Before(term.StartDate) || term.RegistrationEnd.Before(term.RegistrationStart) {
+		return c.String(http.StatusBadRequest, "End date must not be before start date.")
+	}
+
+	query := "INSERT INTO `terms` (`id`, `name`, `start_date`, `end_date`, `registration_start`, `registration_end`) VALUES (?, ?, ?, ?, ?, ?)"
+	args := []interface{}{term.ID, term.Name, req.StartDate, req.EndDate, req.RegistrationStart, req.RegistrationEnd}
+	if _, err := h.DB.Exec(query, args...); err != nil {
+		if mysqlErr, ok := err.(*mysql.MySQLError); ok && mysqlErr.Number == uint16(mysqlErrNumDuplicateEntry) {
+			return c.String(http.StatusConflict, "A term with the same name already exists.")
+		}
+		c.Logger().Error(err)
+		return c.NoContent(http.StatusInternalServerError)
+	}
+	if _, err := h.SubDB.Exec(query, args...); err != nil {
+		c.Logger().Error(err)
+		return c.NoContent(http.StatusInternalServerError)
+	}
+
+	TermCacheMux.Lock()
+	TermCacheMap[term.ID] = term
+	TermCacheMux.Unlock()
+
+	return c.JSON(http.StatusCreated, AddTermResponse{ID: term.ID})
+}
+
+type TermSummary struct {
+	TermID  string  `json:"term_id"`
+	Name    string  `json:"name"`
+	Credits int     `json:"credits"`
+	GPA     float64 `json:"gpa"`
+}
+
+// calcTermSummaries 修了した科目から学期毎のGPAを計算する
+func (h *handlers) calcTermSummaries(courses []Course, totalScores map[string]int) []TermSummary {
+	type acc struct {
+		term    *Term
+		score   int
+		credits int
+	}
+	accs := map[string]*acc{}
+	for _, course := range courses {
+		if course.Status != StatusClosed || !course.TermID.Valid {
+			continue
+		}
+		a, ok := accs[course.TermID.String]
+		if !ok {
+			found, term := h.getTerm(course.TermID.String)
+			if !found {
+				continue
+			}
+			a = &acc{term: term}
+			accs[course.TermID.String] = a
+		}
+		a.score += totalScores[course.ID] * int(course.Credit)
+		a.credits += int(course.Credit)
+	}
+
+	summaries := make([]TermSummary, 0, len(accs))
+	sorted := make([]*acc, 0, len(accs))
+	for _, a := range accs {
+		sorted = append(sorted, a)
+	}
+	sort.Slice(sorted, func(i, j int) bool {
+		return sorted[i].term.StartDate.Before(sorted[j].term.StartDate)
+	})
+	for _, a := range sorted {
+		gpa := 0.0
+		if a.credits > 0 {
+			gpa = float64(a.score) / 100 / float64(a.credits)
+		}
+		summaries = append(summaries, TermSummary{
+			TermID:  a.term.ID,
+			Name:    a.term.Name,
+			Credits: a.credits,
+			GPA:     gpa,
+		})
+	}
+	return summaries
+}
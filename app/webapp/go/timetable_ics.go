@@ -0,0 +1,256 @@
+package main
+
+import (
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/labstack/echo/v4"
+)
+
+// 時限毎の開始・終了時刻(Asia/Tokyo)
+var periodTimes = map[uint8][2]string{
+	1: {"0900", "1030"},
+	2: {"1040", "1210"},
+	3: {"1300", "1430"},
+	4: {"1440", "1610"},
+	5: {"1620", "1750"},
+	6: {"1800", "1930"},
+}
+
+const calendarTZID = "Asia/Tokyo"
+
+var calendarTimezone = "BEGIN:VTIMEZONE\r\n" +
+	"TZID:" + calendarTZID + "\r\n" +
+	"BEGIN:STANDARD\r\n" +
+	"DTSTART:19700101T000000\r\n" +
+	"TZOFFSETFROM:+0900\r\n" +
+	"TZOFFSETTO:+0900\r\n" +
+	"TZNAME:JST\r\n" +
+	"END:STANDARD\r\n" +
+	"END:VTIMEZONE\r\n"
+
+var icsTextEscaper = strings.NewReplacer("\\", "\\\\", ";", "\\;", ",", "\\,", "\r\n", "\\n", "\n", "\\n")
+
+// writeICSLine 75オクテットを超える行はRFC5545に従って折り返す
+func writeICSLine(sb *strings.Builder, line string) {
+	for len(line) > 75 {
+		cut := 75
+		// UTF-8の途中で切らない
+		for cut > 0 && line[cut]&0xC0 == 0x80 {
+			cut--
+		}
+		sb.WriteString(line[:cut])
+		sb.WriteString("\r\n ")
+		line = line[cut:]
+	}
+	sb.WriteString(line)
+	sb.WriteString("\r\n")
+}
+
+// firstWeekday from以降で最初にdayの曜日になる日
+func firstWeekday(from time.Time, day DayOfWeek) time.Time {
+	target := time.Weekday(dayOfWeekIndex(day) + 1)
+	diff := (int(target) - int(from.Weekday()) + 7) % 7
+	return from.AddDate(0, 0, diff)
+}
+
+// currentTerm 今日を含む学期。学期が設定されていない科目はこの学期の期間で出力する
+func (h *handlers) currentTerm() (*Term, error) {
+	var term Term
+	today := time.Now().Format(dateFormat)
+	if err := h.Balance().Get(&term, "SELECT * FROM `terms` WHERE `start_date` <= ? AND `end_date` >= ? ORDER BY `start_date` DESC LIMIT 1", today, today); err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return &term, nil
+}
+
+// buildTimetableICS 履修中の科目の時限を毎週繰り返すイベントとして出力する
+func (h *handlers) buildTimetableICS(userID string) (string, error) {
+	var courses []Course
+	query := "SELECT `courses`.*" +
+		" FROM `courses`" +
+		" JOIN `registrations` ON `courses`.`id` = `registrations`.`course_id`" +
+		" WHERE `courses`.`status` != ? AND `registrations`.`user_id` = ?"
+	if err := h.Balance().Select(&courses, query, StatusClosed, userID); err != nil {
+		return "", err
+	}
+
+	current, err := h.currentTerm()
+	if err != nil {
+		return "", err
+	}
+
+	var sb strings.Builder
+	sb.WriteString("BEGIN:VCALENDAR\r\n")
+	sb.WriteString("VERSION:2.0\r\n")
+	sb.WriteString("PRODID:-//isucholar//timetable//JA\r\n")
+	sb.WriteString("CALSCALE:GREGORIAN\r\n")
+	sb.WriteString(calendarTimezone)
+
+	dtstamp := time.Now().UTC().Format("20060102T150405Z")
+	for _, course := range courses {
+		term := current
+		if course.TermID.Valid {
+			ok, t := h.getTerm(course.TermID.String)
+			if ok {
+				term = t
+			}
+		}
+		if term == nil {
+			continue
+		}
+
+		slots, err := h.getCourseSlots(course.ID, course.primarySlot())
+		if err != nil {
+			return "", err
+		}
+		// UNTILはUTCで指定する必要があるので、学期最終日の23:59:59(JST)を変換する
+		until := term.EndDate.Format("20060102") + "T145959Z"
+		for _, slot := range slots {
+			times, ok := periodTimes[slot.Period]
+			if !ok {
+				continue
+			}
+			first := firstWeekday(term.StartDate, slot.DayOfWeek).Format("20060102")
+			sb.WriteString("BEGIN:VEVENT\r\n")
+			writeICSLine(&sb, fmt.Sprintf("UID:%s-%s-%d@isucholar", course.ID, slot.DayOfWeek, slot.Period))
+			writeICSLine(&sb, "DTSTAMP:"+dtstamp)
+			writeICSLine(&sb, "DTSTART;TZID="+calendarTZID+":"+first+"T"+times[0]+"00")
+			writeICSLine(&sb, "DTEND;TZID="+calendarTZID+":"+first+"T"+times[1]+"00")
+			writeICSLine(&sb, "RRULE:FREQ=WEEKLY;UNTIL="+until)
+			writeICSLine(&sb, "SUMMARY:"+icsTextEscaper.Replace(course.Name))
+			writeICSLine(&sb, "DESCRIPTION:"+icsTextEscaper.Replace(fmt.Sprintf("%s (%d限)", course.Code, slot.Period)))
+			sb.WriteString("END:VEVENT\r\n")
+		}
+
+		// 日付が設定されている講義は個別のイベントとして出力する
+		var classes []Class
+		if err := h.Balance().Select(&classes, "SELECT * FROM `classes` WHERE `course_id` = ? AND `held_on` IS NOT NULL ORDER BY `part`", course.ID); err != nil {
+			return "", err
+		}
+		for _, class := range classes {
+			day := class.HeldOn.Time.Format("20060102")
+			sb.WriteString("BEGIN:VEVENT\r\n")
+			writeICSLine(&sb, "UID:"+class.ID+"@isucholar")
+			writeICSLine(&sb, "DTSTAMP:"+dtstamp)
+			writeICSLine(&sb, "DTSTART;VALUE=DATE:"+day)
+			writeICSLine(&sb, "SUMMARY:"+icsTextEscaper.Replace(fmt.Sprintf("%s 第%d回 %s", course.Name, class.Part, class.Title)))
+			writeICSLine(&sb, "DESCRIPTION:"+icsTextEscaper.Replace(class.Description))
+			sb.WriteString("END:VEVENT\r\n")
+		}
+	}
+
+	sb.WriteString("END:VCALENDAR\r\n")
+	return sb.String(), nil
+}
+
+func icsResponse(c echo.Context, body string) error {
+	c.Response().Header().Set("Content-Disposition", "inline; filename=\"timetable.ics\"")
+	return c.Blob(http.StatusOK, "text/calendar; charset=utf-8", []byte(body))
+}
+
+// GetTimetableICS GET /api/users/me/timetable.ics 時間割のiCalendar形式での取得
+func (h *handlers) GetTimetableICS(c echo.Context) error {
+	userID, _, _, err := getUserInfo(c)
+	if err != nil {
+		c.Logger().Error(err)
+		return c.NoContent(http.StatusInternalServerError)
+	}
+
+	body, err := h.buildTimetableICS(userID)
+	if err != nil {
+		c.Logger().Error(err)
+		return c.NoContent(http.StatusInternalServerError)
+	}
+	return icsResponse(c, body)
+}
+
+type CalendarFeedResponse struct {
+	URL string `json:"url"`
+}
+
+func newCalendarFeedToken() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+func calendarFeedURL(token string) string {
+	return "/calendar/" + token + ".ics"
+}
+
+// GetCalendarFeed GET /api/users/me/timetable/feed 認証なしで購読できる時間割のURLを取得
+func (h *handlers) GetCalendarFeed(c echo.Context) error {
+	userID, _, _, err := getUserInfo(c)
+	if err != nil {
+		c.Logger().Error(err)
+		return c.NoContent(http.StatusInternalServerError)
+	}
+
+	var token string
+	if err := h.DB.Get(&token, "SELECT `token` FROM `calendar_feeds` WHERE `user_id` = ?", userID); err != nil && err != sql.ErrNoRows {
+		c.Logger().Error(err)
+		return c.NoContent(http.StatusInternalServerError)
+	} else if err == sql.ErrNoRows {
+		return h.ResetCalendarFeed(c)
+	}
+
+	return c.JSON(http.StatusOK, CalendarFeedResponse{URL: calendarFeedURL(token)})
+}
+
+// ResetCalendarFeed POST /api/users/me/timetable/feed 時間割のURLを再発行し、古いURLを無効にする
+func (h *handlers) ResetCalendarFeed(c echo.Context) error {
+	userID, _, _, err := getUserInfo(c)
+	if err != nil {
+		c.Logger().Error(err)
+		return c.NoContent(http.StatusInternalServerError)
+	}
+
+	token, err := newCalendarFeedToken()
+	if err != nil {
+		c.Logger().Error(err)
+		return c.NoContent(http.StatusInternalServerError)
+	}
+
+	query := "INSERT INTO `calendar_feeds` (`user_id`, `token`) VALUES (?, ?) ON DUPLICATE KEY UPDATE `token` = VALUES(`token`)"
+	if _, err := h.DB.Exec(query, userID, token); err != nil {
+		c.Logger().Error(err)
+		return c.NoContent(http.StatusInternalServerError)
+	}
+	if _, err := h.SubDB.Exec(query, userID, token); err != nil {
+		c.Logger().Error(err)
+		return c.NoContent(http.StatusInternalServerError)
+	}
+
+	return c.JSON(http.StatusOK, CalendarFeedResponse{URL: calendarFeedURL(token)})
+}
+
+// GetCalendarFeedICS GET /calendar/:token.ics 秘密のURLによる時間割の取得(セッション不要)
+func (h *handlers) GetCalendarFeedICS(c echo.Context) error {
+	token := strings.TrimSuffix(c.Param("token"), ".ics")
+
+	var userID string
+	if err := h.DB.Get(&userID, "SELECT `user_id` FROM `calendar_feeds` WHERE `token` = ?", token); err != nil && err != sql.ErrNoRows {
+		c.Logger().Error(err)
+		return c.NoContent(http.StatusInternalServerError)
+	} else if err == sql.ErrNoRows {
+		return c.String(http.StatusNotFound, "No such calendar.")
+	}
+
+	body, err := h.buildTimetableICS(userID)
+	if err != nil {
+		c.Logger().Error(err)
+		return c.NoContent(http.StatusInternalServerError)
+	}
+	return icsResponse(c, body)
+}
@@ -1,6 +1,7 @@
 package main
 
 import (
+	"database/sql"
 	"math"
 	"math/rand"
 	"os"
@@ -27,6 +28,22 @@ func contains(arr []DayOfWeek, day DayOfWeek) bool {
 	return false
 }
 
+func nullStringPtr(s sql.NullString) *string {
+	if !s.Valid {
+		return nil
+	}
+	v := s.String
+	return &v
+}
+
+func nullDatePtr(t sql.NullTime) *string {
+	if !t.Valid {
+		return nil
+	}
+	v := t.Time.Format(dateFormat)
+	return &v
+}
+
 var (
 	entropy     = ulid.Monotonic(rand.New(rand.NewSource(time.Now().UnixNano())), 0)
 	entropyLock sync.Mutex
@@ -0,0 +1,272 @@
+package main
+
+import (
+	"net/http"
+
+	"github.com/go-sql-driver/mysql"
+	"github.com/jmoiron/sqlx"
+	"github.com/labstack/echo/v4"
+)
+
+// countCourseRegistrations 科目の履修者数を数える
+// トランザクション開始時のスナップショットではなく最新の値を読むため、ロック読み取りにする
+func countCourseRegistrations(tx *sqlx.Tx, courseID string) (int, error) {
+	var count int
+	if err := tx.Get(&count, "SELECT COUNT(*) FROM `registrations` WHERE `course_id` = ? FOR UPDATE", courseID); err != nil {
+		return 0, err
+	}
+	return count, nil
+}
+
+// lockCourseSeats 科目の行をロックし、定員に空きがあるかを返す
+// 同じトランザクション内で履修登録を行うことで座席を確保する
+func lockCourseSeats(tx *sqlx.Tx, course *Course) (bool, error) {
+	if !course.Capacity.Valid {
+		return true, nil
+	}
+
+	var id string
+	if err := tx.Get(&id, "SELECT `id` FROM `courses` WHERE `id` = ? FOR UPDATE", course.ID); err != nil {
+		return false, err
+	}
+	count, err := countCourseRegistrations(tx, course.ID)
+	if err != nil {
+		return false, err
+	}
+	return count < int(course.Capacity.Int32), nil
+}
+
+// hasCourseSeats 行ロックを取らずに定員に空きがあるかを返す。事前検査用で座席は確保しない
+func hasCourseSeats(db sqlx.Queryer, course *Course) (bool, error) {
+	if !course.Capacity.Valid {
+		return true, nil
+	}
+
+	var count int
+	if err := sqlx.Get(db, &count, "SELECT COUNT(*) FROM `registrations` WHERE `course_id` = ?", course.ID); err != nil {
+		return false, err
+	}
+	return count < int(course.Capacity.Int32), nil
+}
+
+// promoteWaitlist 空席ができた科目にキャンセル待ちの学生を先着順に繰り上げる
+// 時間割が重複する学生、前提科目を満たさない学生、単位数の上限を超える学生は飛ばす。繰り上がった学生のIDを返す
+func (h *handlers) promoteWaitlist(tx *sqlx.Tx, course *Course) ([]string, error) {
+	if !h.isCourseRegistrable(course) {
+		return nil, nil
+	}
+
+	var id string
+	if err := tx.Get(&id, "SELECT `id` FROM `courses` WHERE `id` = ? FOR UPDATE", course.ID); err != nil {
+		return nil, err
+	}
+
+	vacancy := -1
+	if course.Capacity.Valid {
+		count, err := countCourseRegistrations(tx, course.ID)
+		if err != nil {
+			return nil, err
+		}
+		vacancy = int(course.Capacity.Int32) - count
+		if vacancy <= 0 {
+			return nil, nil
+		}
+	}
+
+	var waiting []string
+	if err := tx.Select(&waiting, "SELECT `user_id` FROM `waitlists` WHERE `course_id` = ? ORDER BY `id`", course.ID); err != nil {
+		return nil, err
+	}
+
+	prerequisites, err := h.getPrerequisites(course.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	var promoted []string
+	for _, userID := range waiting {
+		if vacancy == 0 {
+			break
+		}
+
+		if len(prerequisites) > 0 {
+			completed, err := getCompletedCourseScores(tx, userID)
+			if err != nil {
+				return nil, err
+			}
+			if !prerequisitesMet(prerequisites, completed) {
+				continue
+			}
+		}
+
+		var registered []Course
+		query := "SELECT `courses`.*" +
+			" FROM `courses`" +
+			" JOIN `registrations` ON `courses`.`id` = `registrations`.`course_id`" +
+			" WHERE `courses`.`status` != ? AND `registrations`.`user_id` = ?"
+		if err := tx.Select(&registered, query, StatusClosed, userID); err != nil {
+			return nil, err
+		}
+		conflict := false
+		for i := range registered {
+			conflicts, err := h.schedulesConflict(course, &registered[i])
+			if err != nil {
+				return nil, err
+			}
+			if conflicts {
+				conflict = true
+				break
+			}
+		}
+		if conflict {
+			continue
+		}
+		// 取り消しではないので最低単位数は確認しない
+		if len(registrationCreditRules.check(append(registered, *course), false)) > 0 {
+			continue
+		}
+
+		h.SubDB.Exec("INSERT INTO `registrations` (`course_id`, `user_id`) VALUES (?, ?) ON DUPLICATE KEY UPDATE `course_id` = VALUES(`course_id`), `user_id` = VALUES(`user_id`)", course.ID, userID)
+		if _, err := tx.Exec("INSERT INTO `registrations` (`course_id`, `user_id`) VALUES (?, ?) ON DUPLICATE KEY UPDATE `course_id` = VALUES(`course_id`), `user_id` = VALUES(`user_id`)", course.ID, userID); err != nil {
+			return nil, err
+		}
+		h.SubDB.Exec("DELETE FROM `waitlists` WHERE `course_id` = ? AND `user_id` = ?", course.ID, userID)
+		if _, err := tx.Exec("DELETE FROM `waitlists` WHERE `course_id` = ? AND `user_id` = ?", course.ID, userID); err != nil {
+			return nil, err
+		}
+
+		promoted = append(promoted, userID)
+		if vacancy > 0 {
+			vacancy--
+		}
+	}
+
+	return promoted, nil
+}
+
+// afterRegistrationChanged 履修登録の変更をコミットした後に呼ぶ
+func afterRegistrationChanged(userIDs ...string) {
+	if len(userIDs) == 0 {
+		return
+	}
+	keys := []string{gradesKey}
+	for _, userID := range userIDs {
+		keys = append(keys, registrationsKey(userID), announcementsKey(userID))
+	}
+	bumpVersion(keys...)
+}
+
+type WaitlistEntry struct {
+	CourseID   string `json:"course_id" db:"course_id"`
+	CourseName string `json:"course_name" db:"course_name"`
+	Position   int    `json:"position" db:"position"`
+}
+
+// GetMyWaitlists GET /api/users/me/waitlists キャンセル待ちの順番一覧
+func (h *handlers) GetMyWaitlists(c echo.Context) error {
+	userID, _, _, err := getUserInfo(c)
+	if err != nil {
+		c.Logger().Error(err)
+		return c.NoContent(http.StatusInternalServerError)
+	}
+
+	// 結果が0件の時は空配列を返却
+	res := make([]WaitlistEntry, 0)
+	query := "SELECT `w`.`course_id`, `courses`.`name` AS `course_name`," +
+		" (SELECT COUNT(*) FROM `waitlists` AS `o` WHERE `o`.`course_id` = `w`.`course_id` AND `o`.`id` <= `w`.`id`) AS `position`" +
+		" FROM `waitlists` AS `w`" +
+		" JOIN `courses` ON `courses`.`id` = `w`.`course_id`" +
+		" WHERE `w`.`user_id` = ?" +
+		" ORDER BY `w`.`id`"
+	if err := h.DB.Select(&res, query, userID); err != nil {
+		c.Logger().Error(err)
+		return c.NoContent(http.StatusInternalServerError)
+	}
+
+	return c.JSON(http.StatusOK, res)
+}
+
+// JoinWaitlist POST /api/courses/:courseID/waitlist 定員に達した科目のキャンセル待ちに登録
+func (h *handlers) JoinWaitlist(c echo.Context) error {
+	userID, _, _, err := getUserInfo(c)
+	if err != nil {
+		c.Logger().Error(err)
+		return c.NoContent(http.StatusInternalServerError)
+	}
+
+	courseID := c.Param("courseID")
+	ok, course := h.getCourse(courseID)
+	if !ok {
+		return c.String(http.StatusNotFound, "No such course.")
+	}
+	if !h.isCourseRegistrable(course) {
+		return c.String(http.StatusBadRequest, "This course is not in the registration period.")
+	}
+
+	tx, err := h.DB.Beginx()
+	if err != nil {
+		c.Logger().Error(err)
+		return c.NoContent(http.StatusInternalServerError)
+	}
+	defer tx.Rollback()
+
+	var count int
+	if err := tx.Get(&count, "SELECT COUNT(*) FROM `registrations` WHERE `course_id` = ? AND `user_id` = ? LIMIT 1", courseID, userID); err != nil {
+		c.Logger().Error(err)
+		return c.NoContent(http.StatusInternalServerError)
+	}
+	if count > 0 {
+		return c.String(http.StatusBadRequest, "You have already taken this course.")
+	}
+
+	hasVacancy, err := lockCourseSeats(tx, course)
+	if err != nil {
+		c.Logger().Error(err)
+		return c.NoContent(http.StatusInternalServerError)
+	}
+	if hasVacancy {
+		return c.String(http.StatusBadRequest, "This course is not full.")
+	}
+
+	h.SubDB.Exec("INSERT INTO `waitlists` (`course_id`, `user_id`) VALUES (?, ?)", courseID, userID)
+	if _, err := tx.Exec("INSERT INTO `waitlists` (`course_id`, `user_id`) VALUES (?, ?)", courseID, userID); err != nil {
+		if mysqlErr, ok := err.(*mysql.MySQLError); ok && mysqlErr.Number == uint16(mysqlErrNumDuplicateEntry) {
+			return c.String(http.StatusConflict, "You are already on the waitlist.")
+		}
+		c.Logger().Error(err)
+		return c.NoContent(http.StatusInternalServerError)
+	}
+
+	if err := tx.Commit(); err != nil {
+		c.Logger().Error(err)
+		return c.NoContent(http.StatusInternalServerError)
+	}
+
+	return c.NoContent(http.StatusCreated)
+}
+
+// LeaveWaitlist DELETE /api/courses/:courseID/waitlist キャンセル待ちの取り消し
+func (h *handlers) LeaveWaitlist(c echo.Context) error {
+	userID, _, _, err := getUserInfo(c)
+	if err != nil {
+		c.Logger().Error(err)
+		return c.NoContent(http.StatusInternalServerError)
+	}
+
+	courseID := c.Param("courseID")
+
+	r, err := h.DB.Exec("DELETE FROM `waitlists` WHERE `course_id` = ? AND `user_id` = ?", courseID, userID)
+	if err != nil {
+		c.Logger().Error(err)
+		return c.NoContent(http.StatusInternalServerError)
+	}
+	if _, err := h.SubDB.Exec("DELETE FROM `waitlists` WHERE `course_id` = ? AND `user_id` = ?", courseID, userID); err != nil {
+		c.Logger().Error(err)
+		return c.NoContent(http.StatusInternalServerError)
+	}
+	if count, _ := r.RowsAffected(); count == 0 {
+		return c.String(http.StatusNotFound, "You are not on the waitlist.")
+	}
+
+	return c.NoContent(http.StatusNoContent)
+}
@@ -1,11 +1,23 @@
 -- CREATEと逆順
+DROP TABLE IF EXISTS `attendance_attempts`;
+DROP TABLE IF EXISTS `class_materials`;
+DROP TABLE IF EXISTS `attendances`;
+DROP TABLE IF EXISTS `attendance_sessions`;
+DROP TABLE IF EXISTS `deadline_extensions`;
+DROP TABLE IF EXISTS `syllabus_revisions`;
+DROP TABLE IF EXISTS `calendar_feeds`;
+DROP TABLE IF EXISTS `course_prerequisites`;
+DROP TABLE IF EXISTS `waitlists`;
 DROP TABLE IF EXISTS `user_course_total_scores`;
 DROP TABLE IF EXISTS `unread_announcements`;
 DROP TABLE IF EXISTS `announcements`;
 DROP TABLE IF EXISTS `submissions`;
 DROP TABLE IF EXISTS `classes`;
 DROP TABLE IF EXISTS `registrations`;
+DROP TABLE IF EXISTS `course_staff`;
+DROP TABLE IF EXISTS `course_slots`;
 DROP TABLE IF EXISTS `courses`;
+DROP TABLE IF EXISTS `terms`;
 DROP TABLE IF EXISTS `users`;
 
 -- master data
@@ -18,6 +30,16 @@ CREATE TABLE `users`
     `type`            ENUM ('student', 'teacher') NOT NULL
 );
 
+CREATE TABLE `terms`
+(
+    `id`                 CHAR(26) PRIMARY KEY,
+    `name`               VARCHAR(255) UNIQUE NOT NULL,
+    `start_date`         DATE                NOT NULL,
+    `end_date`           DATE                NOT NULL,
+    `registration_start` DATE                NOT NULL,
+    `registration_end`   DATE                NOT NULL
+);
+
 CREATE TABLE `courses`
 (
     `id`          CHAR(26) PRIMARY KEY,
@@ -31,7 +53,33 @@ CREATE TABLE `courses`
     `teacher_id`  CHAR(26)                                                      NOT NULL,
     `keywords`    TEXT                                                          NOT NULL,
     `status`      ENUM ('registration', 'in-progress', 'closed')                NOT NULL DEFAULT 'registration',
-    INDEX (`teacher_id`)
+    `version`     INT UNSIGNED                                                  NOT NULL DEFAULT 0,
+    `term_id`     CHAR(26),
+    `capacity`    INT UNSIGNED,
+    `archived_at` DATETIME(6),
+    INDEX (`teacher_id`),
+    INDEX (`term_id`),
+    FULLTEXT INDEX `idx_courses_fulltext` (`name`, `keywords`, `description`) WITH PARSER ngram
+);
+
+-- 科目の毎週の開講時限。courses.period, courses.day_of_week は代表(最初)の時限
+CREATE TABLE `course_slots`
+(
+    `course_id`   CHAR(26)                                                      NOT NULL,
+    `day_of_week` ENUM ('monday', 'tuesday', 'wednesday', 'thursday', 'friday') NOT NULL,
+    `period`      TINYINT UNSIGNED                                              NOT NULL,
+    PRIMARY KEY (`course_id`, `day_of_week`, `period`),
+    INDEX (`day_of_week`, `period`)
+);
+
+-- 科目の担当教員。roleがownerの教員は courses.teacher_id と一致する
+CREATE TABLE `course_staff`
+(
+    `course_id` CHAR(26)                      NOT NULL,
+    `user_id`   CHAR(26)                      NOT NULL,
+    `role`      ENUM ('owner', 'co-teacher') NOT NULL,
+    PRIMARY KEY (`course_id`, `user_id`),
+    INDEX (`user_id`)
 );
 
 CREATE TABLE `registrations`
@@ -45,20 +93,28 @@ CREATE TABLE `registrations`
 CREATE TABLE `classes`
 (
     `id`                CHAR(26) PRIMARY KEY,
-    `course_id`         CHAR(26)         NOT NULL,
-    `part`              TINYINT UNSIGNED NOT NULL,
-    `title`             VARCHAR(255)     NOT NULL,
-    `description`       TEXT             NOT NULL,
-    `submission_closed` TINYINT(1)       NOT NULL DEFAULT false,
-    UNIQUE KEY `idx_classes_course_id_part` (`course_id`, `part`)
+    `course_id`         CHAR(26)                         NOT NULL,
+    `part`              TINYINT UNSIGNED                 NOT NULL,
+    `title`             VARCHAR(255)                     NOT NULL,
+    `description`       TEXT                             NOT NULL,
+    `submission_closed` TINYINT(1)                       NOT NULL DEFAULT false,
+    `held_on`           DATE,
+    `deadline`          DATETIME(6),
+    `grace_period`      INT UNSIGNED                     NOT NULL DEFAULT 0,
+    `late_policy`       ENUM ('none', 'per-day', 'zero') NOT NULL DEFAULT 'none',
+    `late_penalty_rate` TINYINT UNSIGNED                 NOT NULL DEFAULT 0,
+    `late_penalty_cap`  TINYINT UNSIGNED                 NOT NULL DEFAULT 100,
+    UNIQUE KEY `idx_classes_course_id_part` (`course_id`, `part`),
+    INDEX `idx_classes_deadline` (`deadline`)
 );
 
 CREATE TABLE `submissions`
 (
-    `user_id`   CHAR(26)     NOT NULL,
-    `class_id`  CHAR(26)     NOT NULL,
-    `file_name` VARCHAR(255) NOT NULL,
-    `score`     TINYINT UNSIGNED,
+    `user_id`      CHAR(26)     NOT NULL,
+    `class_id`     CHAR(26)     NOT NULL,
+    `file_name`    VARCHAR(255) NOT NULL,
+    `score`        TINYINT UNSIGNED,
+    `submitted_at` DATETIME(6)  NOT NULL DEFAULT CURRENT_TIMESTAMP(6),
     PRIMARY KEY (`user_id`, `class_id`),
     INDEX (`class_id`)
 );
@@ -89,3 +145,98 @@ CREATE TABLE `user_course_total_scores`
     PRIMARY KEY (`user_id`, `course_id`),
     INDEX (`course_id`)
 );
+
+CREATE TABLE `waitlists`
+(
+    `id`         BIGINT UNSIGNED AUTO_INCREMENT PRIMARY KEY,
+    `course_id`  CHAR(26)    NOT NULL,
+    `user_id`    CHAR(26)    NOT NULL,
+    `created_at` DATETIME(6) NOT NULL DEFAULT CURRENT_TIMESTAMP(6),
+    UNIQUE KEY `idx_waitlists_course_id_user_id` (`course_id`, `user_id`),
+    INDEX (`user_id`)
+);
+
+CREATE TABLE `course_prerequisites`
+(
+    `course_id`         CHAR(26)     NOT NULL,
+    `prerequisite_code` VARCHAR(255) NOT NULL,
+    `min_total_score`   INT UNSIGNED NOT NULL DEFAULT 0,
+    PRIMARY KEY (`course_id`, `prerequisite_code`),
+    INDEX (`prerequisite_code`)
+);
+
+CREATE TABLE `calendar_feeds`
+(
+    `user_id` CHAR(26) PRIMARY KEY,
+    `token`   CHAR(32) UNIQUE NOT NULL
+);
+
+-- シラバス(科目の説明とキーワード)の版
+CREATE TABLE `syllabus_revisions`
+(
+    `course_id`   CHAR(26)     NOT NULL,
+    `revision`    INT UNSIGNED NOT NULL,
+    `description` TEXT         NOT NULL,
+    `keywords`    TEXT         NOT NULL,
+    `author_id`   CHAR(26)     NOT NULL,
+    `created_at`  DATETIME(6)  NOT NULL,
+    PRIMARY KEY (`course_id`, `revision`)
+);
+
+-- 学生個別の課題の提出期限の延長
+CREATE TABLE `deadline_extensions`
+(
+    `class_id`   CHAR(26)    NOT NULL,
+    `user_id`    CHAR(26)    NOT NULL,
+    `deadline`   DATETIME(6) NOT NULL,
+    `reason`     TEXT        NOT NULL,
+    `granted_by` CHAR(26)    NOT NULL,
+    `created_at` DATETIME(6) NOT NULL,
+    PRIMARY KEY (`class_id`, `user_id`),
+    INDEX (`user_id`)
+);
+
+-- 講義の出席受付。学生は受付時間内にワンタイムコードを送信して出席する
+CREATE TABLE `attendance_sessions`
+(
+    `id`         CHAR(26) PRIMARY KEY,
+    `class_id`   CHAR(26)    NOT NULL,
+    `code`       CHAR(6)     NOT NULL,
+    `opens_at`   DATETIME(6) NOT NULL,
+    `expires_at` DATETIME(6) NOT NULL,
+    `created_by` CHAR(26)    NOT NULL,
+    INDEX (`class_id`, `expires_at`)
+);
+
+CREATE TABLE `attendances`
+(
+    `class_id`    CHAR(26)                                      NOT NULL,
+    `user_id`     CHAR(26)                                      NOT NULL,
+    `status`      ENUM ('present', 'late', 'absent', 'excused') NOT NULL,
+    `session_id`  CHAR(26),
+    `recorded_at` DATETIME(6)                                   NOT NULL,
+    PRIMARY KEY (`class_id`, `user_id`),
+    INDEX (`user_id`)
+);
+
+-- 講義資料。ファイルは課題と同じディレクトリに保存する
+CREATE TABLE `class_materials`
+(
+    `id`           CHAR(26) PRIMARY KEY,
+    `class_id`     CHAR(26)        NOT NULL,
+    `file_name`    VARCHAR(255)    NOT NULL,
+    `content_type` VARCHAR(255)    NOT NULL,
+    `size`         BIGINT UNSIGNED NOT NULL,
+    `uploaded_by`  CHAR(26)        NOT NULL,
+    `created_at`   DATETIME(6)     NOT NULL,
+    INDEX (`class_id`)
+);
+
+-- 出席コードの誤入力回数。受付毎・学生毎に上限を超えたら送信を拒否する
+CREATE TABLE `attendance_attempts`
+(
+    `session_id` CHAR(26)         NOT NULL,
+    `user_id`    CHAR(26)         NOT NULL,
+    `failures`   TINYINT UNSIGNED NOT NULL DEFAULT 0,
+    PRIMARY KEY (`session_id`, `user_id`)
+);
@@ -0,0 +1,14 @@
+-- 単一の時限しか持たない既存の科目の時限をcourse_slotsに移行する
+INSERT INTO `course_slots` (`course_id`, `day_of_week`, `period`)
+SELECT `id`, `day_of_week`, `period` FROM `courses`
+ON DUPLICATE KEY UPDATE `course_id` = VALUES(`course_id`);
+
+-- 既存の科目の担当教員をownerとしてcourse_staffに移行する
+INSERT INTO `course_staff` (`course_id`, `user_id`, `role`)
+SELECT `id`, `teacher_id`, 'owner' FROM `courses`
+ON DUPLICATE KEY UPDATE `role` = VALUES(`role`);
+
+-- 既存の科目のシラバスを第1版として記録する
+INSERT INTO `syllabus_revisions` (`course_id`, `revision`, `description`, `keywords`, `author_id`, `created_at`)
+SELECT `id`, 1, `description`, `keywords`, `teacher_id`, NOW(6) FROM `courses`
+ON DUPLICATE KEY UPDATE `revision` = VALUES(`revision`);